@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+type Config struct {
+	// FetchTimeout bounds a single feed request
+	FetchTimeout time.Duration
+	// FetchAllowedNetworks are reachable by the fetcher even though they are not public
+	FetchAllowedNetworks []netip.Prefix
+	// FetchAllowedPorts are the ports the fetcher may request
+	FetchAllowedPorts []int
+	// FetchMaxBodySize and FetchMaxDecompressedSize cap a response body in bytes, before and after decompression
+	FetchMaxBodySize         int
+	FetchMaxDecompressedSize int
+	// FetchMaxRedirects caps the redirects followed by a request
+	FetchMaxRedirects int
+	// FetchUserAgent, FetchProxy and FetchHeaders apply to the requests of every feed not setting its own
+	FetchUserAgent string
+	FetchProxy     string
+	FetchHeaders   map[string]string
+	// EncryptionKey seals the credentials of feeds at rest, so subscribing with credentials requires it
+	EncryptionKey []byte
+	// PollTimeout bounds a whole polling cycle over all subscriptions
+	PollTimeout time.Duration
+	// PollConcurrency caps the feeds fetched at the same time
+	PollConcurrency int
+	// PollPerHostConcurrency caps the feeds fetched at the same time from one host
+	PollPerHostConcurrency int
+	// PollInterval is how often a feed is polled unless its subscriptions ask otherwise
+	PollInterval time.Duration
+	// MinPollInterval is the shortest interval a subscription may ask for or a feed may be learned to need
+	MinPollInterval time.Duration
+	// MaxPollInterval is the longest interval a feed may be learned to need or a publisher may ask for
+	MaxPollInterval time.Duration
+	// FailureThreshold is how many consecutive fetch failures mark a subscription broken
+	FailureThreshold int
+	// NotFoundThreshold is how many 404 responses in a row disable a subscription
+	NotFoundThreshold int
+	// PollTick is how often the scheduler looks for due feeds
+	PollTick time.Duration
+	// RetentionMaxAge is how long an entry gone from its feed is kept after it was first saved
+	RetentionMaxAge time.Duration
+	// RetentionMaxEntries is how many of the latest entries of a feed are kept once gone from it
+	RetentionMaxEntries int
+	// PruneInterval is how often the entries past the retention are deleted
+	PruneInterval time.Duration
+	// DeliveryRetryInterval is the wait before retrying a failed post, doubled on every failure
+	DeliveryRetryInterval time.Duration
+	// DeliveryMaxAttempts is how many times a post is attempted before giving up
+	DeliveryMaxAttempts int
+	// WebSubCallbackURL is the public URL the WebSub hubs call back, WebSub is disabled when empty
+	WebSubCallbackURL string
+	// WebSubListenAddr is the address the WebSub callbacks are served on
+	WebSubListenAddr string
+	// WebSubLease is the lease asked to the WebSub hubs
+	WebSubLease time.Duration
+}
+
+func Load() Config {
+	return Config{
+		FetchTimeout:             duration("FETCH_TIMEOUT", 30*time.Second),
+		FetchAllowedNetworks:     prefixes("FETCH_ALLOWED_NETWORKS"),
+		FetchAllowedPorts:        ports("FETCH_ALLOWED_PORTS", []int{80, 443}),
+		FetchMaxBodySize:         positiveInt("FETCH_MAX_BODY_SIZE", 10<<20),
+		FetchMaxDecompressedSize: positiveInt("FETCH_MAX_DECOMPRESSED_SIZE", 50<<20),
+		FetchMaxRedirects:        positiveInt("FETCH_MAX_REDIRECTS", 10),
+		FetchUserAgent:           os.Getenv("FETCH_USER_AGENT"),
+		FetchProxy:               os.Getenv("FETCH_PROXY"),
+		FetchHeaders:             headers("FETCH_HEADERS"),
+		EncryptionKey:            key("ENCRYPTION_KEY"),
+		PollTimeout:              duration("POLL_TIMEOUT", 5*time.Minute),
+		PollConcurrency:          positiveInt("POLL_CONCURRENCY", 8),
+		PollPerHostConcurrency:   positiveInt("POLL_PER_HOST_CONCURRENCY", 2),
+		PollInterval:             duration("POLL_INTERVAL", 10*time.Minute),
+		MinPollInterval:          duration("MIN_POLL_INTERVAL", time.Minute),
+		MaxPollInterval:          duration("MAX_POLL_INTERVAL", 24*time.Hour),
+		FailureThreshold:         positiveInt("FAILURE_THRESHOLD", 5),
+		NotFoundThreshold:        positiveInt("NOT_FOUND_THRESHOLD", 10),
+		PollTick:                 duration("POLL_TICK", time.Minute),
+		RetentionMaxAge:          duration("RETENTION_MAX_AGE", 90*24*time.Hour),
+		RetentionMaxEntries:      positiveInt("RETENTION_MAX_ENTRIES", 1000),
+		PruneInterval:            duration("PRUNE_INTERVAL", time.Hour),
+		DeliveryRetryInterval:    duration("DELIVERY_RETRY_INTERVAL", time.Minute),
+		DeliveryMaxAttempts:      positiveInt("DELIVERY_MAX_ATTEMPTS", 10),
+		WebSubCallbackURL:        os.Getenv("WEBSUB_CALLBACK_URL"),
+		WebSubListenAddr:         str("WEBSUB_LISTEN_ADDR", ":8080"),
+		WebSubLease:              duration("WEBSUB_LEASE", 10*24*time.Hour),
+	}
+}
+
+func str(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Warn(fmt.Sprintf("invalid %s %q, using %v", key, v, def))
+		return def
+	}
+	return d
+}
+
+func positiveInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		slog.Warn(fmt.Sprintf("invalid %s %q, using %v", key, v, def))
+		return def
+	}
+	return i
+}
+
+// prefixes parses a comma separated list of networks, such as 10.0.0.0/8,fd00::/8.
+// A single address stands for itself, and an invalid entry is skipped.
+func prefixes(key string) []netip.Prefix {
+	var res []netip.Prefix
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				slog.Warn(fmt.Sprintf("invalid network %q in %s, skipping it", v, key))
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		res = append(res, p.Masked())
+	}
+	return res
+}
+
+// ports parses a comma separated list of ports, falling back to def when any of them is invalid.
+func ports(key string, def []int) []int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	res := []int{}
+	for _, s := range strings.Split(v, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || p <= 0 || p > 65535 {
+			slog.Warn(fmt.Sprintf("invalid %s %q, using %v", key, v, def))
+			return def
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// headers parses headers given as "Name: value; Name: value", skipping all of them when any is invalid.
+func headers(key string) map[string]string {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	h, err := model.ParseHeaders(v)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("invalid %s: %v, ignoring it", key, err))
+		return nil
+	}
+	return h
+}
+
+// key decodes a base64 AES key of 16, 24 or 32 bytes, such as the output of openssl rand -base64 32.
+func key(name string) []byte {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(b) != 16 && len(b) != 24 && len(b) != 32 {
+		slog.Warn(fmt.Sprintf("invalid %s, it must be 16, 24 or 32 bytes encoded in base64", name))
+		return nil
+	}
+	return b
+}
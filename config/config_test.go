@@ -0,0 +1,52 @@
+package config_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/config"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want config.Config
+	}{
+		{
+			name: "default",
+			env:  map[string]string{},
+			want: config.Config{FetchTimeout: 30 * time.Second, FetchAllowedPorts: []int{80, 443}, FetchMaxBodySize: 10 << 20, FetchMaxDecompressedSize: 50 << 20, FetchMaxRedirects: 10, PollTimeout: 5 * time.Minute, PollConcurrency: 8, PollPerHostConcurrency: 2, PollInterval: 10 * time.Minute, MinPollInterval: time.Minute, MaxPollInterval: 24 * time.Hour, FailureThreshold: 5, NotFoundThreshold: 10, PollTick: time.Minute, RetentionMaxAge: 2160 * time.Hour, RetentionMaxEntries: 1000, PruneInterval: time.Hour, DeliveryRetryInterval: time.Minute, DeliveryMaxAttempts: 10, WebSubListenAddr: ":8080", WebSubLease: 240 * time.Hour},
+		},
+		{
+			name: "override",
+			env:  map[string]string{"FETCH_TIMEOUT": "5s", "FETCH_ALLOWED_NETWORKS": "10.0.0.0/8, 192.168.1.10,invalid", "FETCH_ALLOWED_PORTS": "80,443,8080", "FETCH_MAX_BODY_SIZE": "1024", "FETCH_MAX_DECOMPRESSED_SIZE": "4096", "FETCH_MAX_REDIRECTS": "3", "POLL_TIMEOUT": "1m", "POLL_CONCURRENCY": "4", "POLL_PER_HOST_CONCURRENCY": "1", "POLL_INTERVAL": "1h", "MIN_POLL_INTERVAL": "5m", "MAX_POLL_INTERVAL": "6h", "FAILURE_THRESHOLD": "3", "NOT_FOUND_THRESHOLD": "4", "POLL_TICK": "30s", "RETENTION_MAX_AGE": "720h", "RETENTION_MAX_ENTRIES": "200", "PRUNE_INTERVAL": "10m", "DELIVERY_RETRY_INTERVAL": "5m", "DELIVERY_MAX_ATTEMPTS": "3", "WEBSUB_CALLBACK_URL": "https://bot.example.com/websub", "WEBSUB_LISTEN_ADDR": ":9000", "WEBSUB_LEASE": "24h", "FETCH_USER_AGENT": "bot/1.0", "FETCH_PROXY": "socks5://proxy:1080", "FETCH_HEADERS": "x-api-key: secret; Accept-Language: ja", "ENCRYPTION_KEY": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+			want: config.Config{FetchTimeout: 5 * time.Second, FetchAllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.10/32")}, FetchAllowedPorts: []int{80, 443, 8080}, FetchMaxBodySize: 1024, FetchMaxDecompressedSize: 4096, FetchMaxRedirects: 3, PollTimeout: time.Minute, PollConcurrency: 4, PollPerHostConcurrency: 1, PollInterval: time.Hour, MinPollInterval: 5 * time.Minute, MaxPollInterval: 6 * time.Hour, FailureThreshold: 3, NotFoundThreshold: 4, PollTick: 30 * time.Second, RetentionMaxAge: 720 * time.Hour, RetentionMaxEntries: 200, PruneInterval: 10 * time.Minute, DeliveryRetryInterval: 5 * time.Minute, DeliveryMaxAttempts: 3, WebSubCallbackURL: "https://bot.example.com/websub", WebSubListenAddr: ":9000", WebSubLease: 24 * time.Hour, FetchUserAgent: "bot/1.0", FetchProxy: "socks5://proxy:1080", FetchHeaders: map[string]string{"X-Api-Key": "secret", "Accept-Language": "ja"}, EncryptionKey: []byte("0123456789abcdef0123456789abcdef")},
+		},
+		{
+			name: "invalid",
+			env:  map[string]string{"FETCH_TIMEOUT": "abc", "POLL_TIMEOUT": "-1m", "POLL_CONCURRENCY": "0", "POLL_PER_HOST_CONCURRENCY": "x", "FETCH_ALLOWED_PORTS": "80,x", "FETCH_HEADERS": "X-Ok: 1; broken", "ENCRYPTION_KEY": "c2hvcnQ="},
+			want: config.Config{FetchTimeout: 30 * time.Second, FetchAllowedPorts: []int{80, 443}, FetchMaxBodySize: 10 << 20, FetchMaxDecompressedSize: 50 << 20, FetchMaxRedirects: 10, PollTimeout: 5 * time.Minute, PollConcurrency: 8, PollPerHostConcurrency: 2, PollInterval: 10 * time.Minute, MinPollInterval: time.Minute, MaxPollInterval: 24 * time.Hour, FailureThreshold: 5, NotFoundThreshold: 10, PollTick: time.Minute, RetentionMaxAge: 2160 * time.Hour, RetentionMaxEntries: 1000, PruneInterval: time.Hour, DeliveryRetryInterval: time.Minute, DeliveryMaxAttempts: 10, WebSubListenAddr: ":8080", WebSubLease: 240 * time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			// test
+			got := config.Load()
+
+			// assert
+			opt := cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })
+			if !cmp.Equal(got, tt.want, opt) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want, opt))
+			}
+		})
+	}
+}
@@ -2,19 +2,35 @@ package di
 
 import (
 	"github.com/bwmarrin/discordgo"
+	"github.com/dev-shimada/discord-rss-bot/config"
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/resolve"
 	"github.com/dev-shimada/discord-rss-bot/interface/discord"
+	"github.com/dev-shimada/discord-rss-bot/interface/websub"
 	"github.com/dev-shimada/discord-rss-bot/usecase"
 	"gorm.io/gorm"
 )
 
-func DiscordHandler(db *gorm.DB, ds *discordgo.Session) discord.DiscordHandler {
+func DiscordHandler(db *gorm.DB, ds *discordgo.Session, cfg config.Config) (discord.DiscordHandler, websub.WebSubHandler) {
 	sr := persistence.NewSubscriptionPersistence(db)
 	rr := persistence.NewRssEntryPersistence(db)
-	su := usecase.NewSubscriptionUsecase(sr)
-	rss := fetch.NewRss()
-	ru := usecase.NewRssEntriesUsecase(rr, rss)
-	dh := discord.NewDiscordHandler(ds, su, ru)
-	return dh
+	fr := persistence.NewFeedPersistence(db)
+	// every URL fetched may come from a user, a feed or a page
+	client := fetch.NewClient(fetch.Guard{Allow: cfg.FetchAllowedNetworks, Ports: cfg.FetchAllowedPorts, MaxBodySize: cfg.FetchMaxBodySize, MaxDecompressedSize: cfg.FetchMaxDecompressedSize, MaxRedirects: cfg.FetchMaxRedirects})
+	defaults := model.FetchOptions{Headers: cfg.FetchHeaders, Proxy: cfg.FetchProxy, UserAgent: cfg.FetchUserAgent}
+	fd := fetch.NewDiscoverer(client, defaults, cfg.FetchTimeout)
+	rs := resolve.NewRegistry(client, defaults, cfg.FetchTimeout, cfg.FetchMaxBodySize)
+	rss := fetch.NewRss(client, defaults, cfg.FetchTimeout)
+	sources := fetch.NewSources(rss, fetch.NewScraper(client, defaults, cfg.FetchTimeout))
+	schedule := usecase.Schedule{Interval: cfg.PollInterval, MinInterval: cfg.MinPollInterval, MaxInterval: cfg.MaxPollInterval, FailureThreshold: cfg.FailureThreshold, NotFoundThreshold: cfg.NotFoundThreshold}
+	hub := fetch.NewHub(client, cfg.FetchTimeout)
+	ru := usecase.NewRssEntriesUsecase(rr, fr, sr, sources, usecase.PollLimits{Concurrency: cfg.PollConcurrency, PerHostConcurrency: cfg.PollPerHostConcurrency}, schedule, usecase.Retention{MaxAge: cfg.RetentionMaxAge, MaxEntries: cfg.RetentionMaxEntries, MaxAttempts: cfg.DeliveryMaxAttempts})
+	wu := usecase.NewWebSubUsecase(fr, sr, hub, rss, ru, usecase.WebSub{CallbackURL: cfg.WebSubCallbackURL, Lease: cfg.WebSubLease})
+	su := usecase.NewSubscriptionUsecase(sr, fr, fd, rs, sources, wu, schedule)
+	du := usecase.NewDeliveryUsecase(persistence.NewDeliveryPersistence(db), rr, usecase.DeliveryRetry{Interval: cfg.DeliveryRetryInterval, MaxAttempts: cfg.DeliveryMaxAttempts})
+	dh := discord.NewDiscordHandler(ds, su, ru, wu, du, cfg.PollTick, cfg.PollTimeout, cfg.PruneInterval)
+	wh := websub.NewWebSubHandler(wu, dh)
+	return dh, wh
 }
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+)
+
+// Status of a delivery
+const (
+	// DeliveryPending marks a delivery queued, or interrupted before it was known to be sent
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	// DeliveryFailed marks a delivery whose last attempt failed, retried until it runs out of attempts
+	DeliveryFailed = "failed"
+)
+
+// What a delivery does
+const (
+	// DeliveryPost posts the entry as a new message
+	DeliveryPost = "post"
+	// DeliveryEdit edits the message the entry was posted as
+	DeliveryEdit = "edit"
+)
+
+// Delivery is an entry posted to the channel of a subscription. The deliveries not sent yet form the outbox,
+// queued along with the entries they deliver.
+type Delivery struct {
+	ID             uint   `gorm:"primaryKey"`
+	SubscriptionID uint   `gorm:"uniqueIndex:idx_deliveries_subscription_entry"`
+	EntryID        uint   `gorm:"uniqueIndex:idx_deliveries_subscription_entry"`
+	Status         string `gorm:"index:idx_deliveries_due;not null;default:sent"`
+	Action         string `gorm:"not null;default:post"`
+	// Nonce is sent with every attempt of a post, so Discord creates its message once however many attempts reach it
+	Nonce string
+	// Attempts counts the failed attempts since the entry was last queued
+	Attempts  int
+	LastError string
+	// MessageID is the Discord message the entry was last posted as
+	MessageID string
+	// NextAttemptAt is when a pending or failed delivery is sent next
+	NextAttemptAt time.Time `gorm:"index:idx_deliveries_due"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	// Subscription and Entry are what is delivered, they are not saved
+	Subscription Subscription `gorm:"-"`
+	Entry        RssEntry     `gorm:"-"`
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+)
+
+// Feed is a feed URL shared by every subscription to it, so it is fetched once per cycle.
+// A page without a feed is scraped into one with Selector, and is a distinct feed for every selector.
+type Feed struct {
+	ID  uint `gorm:"primaryKey"`
+	URL string
+	// URLHash is the hash of URL indexed in its place, as MySQL could only index a prefix of it. It is written only.
+	URLHash  string   `gorm:"size:64;serializer:keyhash;uniqueIndex:idx_feeds_source;not null"`
+	Selector Selector `gorm:"embedded;embeddedPrefix:selector_"`
+	// Options customizes the requests, and OptionsDigest tells the feeds of a URL with different options apart
+	// without revealing them, so a feed with credentials is only shared by the subscriptions giving the same ones.
+	Options       FetchOptions `gorm:"serializer:sealed"`
+	OptionsDigest string       `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+	Title         string
+	SiteLink      string
+	IconURL       string
+	ETag          string
+	LastModified  string
+	// TTL, SkipHours and SkipDays are the polling hints of the last fetched RSS channel
+	TTL       time.Duration
+	SkipHours []int          `gorm:"serializer:json"`
+	SkipDays  []time.Weekday `gorm:"serializer:json"`
+	// ConsecutiveFailures counts the fetches failed since LastSuccessAt, LastError being the latest reason
+	ConsecutiveFailures int
+	LastError           string
+	LastSuccessAt       time.Time
+	// Repairs lists what the last document fetched needed fixed to be parsed, empty when it was well-formed
+	Repairs []string `gorm:"serializer:json"`
+	// ConsecutiveNotFound counts the 404 responses in a row
+	ConsecutiveNotFound int
+	// HubURL and TopicURL are the WebSub hub and topic advertised by the feed
+	HubURL   string
+	TopicURL string
+	// WebSubSecret, WebSubRequestedAt and WebSubExpiresAt are the state of the subscription to the hub,
+	// only written through FeedRepository.UpdateWebSub
+	WebSubSecret      string
+	WebSubRequestedAt time.Time
+	WebSubExpiresAt   time.Time
+	// LastCheckedAt and NextDueAt persist the schedule across restarts
+	LastCheckedAt time.Time
+	NextDueAt     time.Time `gorm:"index"`
+	// LiveAt is when the whole document of the feed was last read, so its entries not seen since are gone from it.
+	// A push may only hold the updated entries, so it never sets it.
+	LiveAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Selector holds the CSS selectors building the items of a scraped page.
+// Title, Link and Date are matched within each item, and a zero Selector means the URL is a feed.
+type Selector struct {
+	Item  string `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+	Title string `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+	Link  string `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+	Date  string `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+}
+
+// Scraped reports whether the feed is built from a page rather than fetched.
+func (f Feed) Scraped() bool {
+	return f.Selector.Item != ""
+}
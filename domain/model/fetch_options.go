@@ -0,0 +1,68 @@
+package model
+
+import (
+	"fmt"
+	"maps"
+	"net/textproto"
+	"strings"
+)
+
+// FetchOptions customizes the requests of a feed. Being sealed at rest, it may hold credentials.
+type FetchOptions struct {
+	Headers map[string]string `json:",omitempty"`
+	// Username and Password are sent as Basic auth, Token as a bearer token
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	Token    string `json:",omitempty"`
+	// Proxy is an http, https or socks5 proxy URL
+	Proxy     string `json:",omitempty"`
+	UserAgent string `json:",omitempty"`
+}
+
+func (o FetchOptions) IsZero() bool {
+	return len(o.Headers) == 0 && o.Username == "" && o.Password == "" && o.Token == "" && o.Proxy == "" && o.UserAgent == ""
+}
+
+// HostBound reports whether o holds credentials or custom headers, which are meant for the host of the feed only.
+func (o FetchOptions) HostBound() bool {
+	return len(o.Headers) > 0 || o.Username != "" || o.Password != "" || o.Token != ""
+}
+
+// Merge returns o with the options it lacks taken from defaults, its own headers overriding those of defaults.
+func (o FetchOptions) Merge(defaults FetchOptions) FetchOptions {
+	headers := maps.Clone(defaults.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	maps.Copy(headers, o.Headers)
+	if len(headers) > 0 {
+		o.Headers = headers
+	}
+	if o.Username == "" && o.Password == "" && o.Token == "" {
+		o.Username, o.Password, o.Token = defaults.Username, defaults.Password, defaults.Token
+	}
+	if o.Proxy == "" {
+		o.Proxy = defaults.Proxy
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = defaults.UserAgent
+	}
+	return o
+}
+
+// ParseHeaders parses headers given as "Name: value; Name: value".
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, h := range strings.Split(s, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header: %q", h)
+		}
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
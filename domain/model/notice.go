@@ -0,0 +1,7 @@
+package model
+
+// Notice is a message about a subscription itself rather than its entries, posted to its channel.
+type Notice struct {
+	ChannelID string
+	Content   string
+}
@@ -5,10 +5,58 @@ import (
 )
 
 type RssEntry struct {
-	ID          uint `gorm:"primaryKey"`
-	RSSURL      string
-	EntryTitle  string
-	EntryLink   string
-	PublishedAt time.Time
-	CreatedAt   time.Time
+	ID     uint `gorm:"primaryKey"`
+	FeedID uint `gorm:"index;uniqueIndex:idx_rss_entries_identity;index:idx_rss_entries_seen"`
+	// Identity tells the entries of a feed apart: the GUID of the item, else its link, else a hash of its content.
+	// The entries saved before identities were keyed by their link hold it as legacy:<link>.
+	Identity string `gorm:"not null;default:''"`
+	// IdentityHash is the hash of Identity indexed in its place, as MySQL could only index a prefix of it.
+	// It is written only.
+	IdentityHash string `gorm:"size:64;serializer:keyhash;uniqueIndex:idx_rss_entries_identity;not null"`
+	EntryTitle   string
+	EntryLink    string
+	PublishedAt  time.Time
+	// Description is the summary of the entry and Content its full text, both HTML as the feed gives them
+	Description string
+	Content     string
+	Authors     []Author    `gorm:"serializer:json"`
+	Categories  []string    `gorm:"serializer:json"`
+	Enclosures  []Enclosure `gorm:"serializer:json"`
+	// ImageURL is the image of the entry, else its first thumbnail
+	ImageURL string
+	// Media lists the media:content and media:thumbnail of the entry
+	Media []Media `gorm:"serializer:json"`
+	// ContentHash tells whether the entry changed since it was saved, empty for the entries saved before it existed
+	ContentHash string `gorm:"not null;default:''"`
+	// SeenAt is when the entry was last in its feed. The entries seen by the latest fetch of their feed are still live,
+	// so they are never pruned, otherwise they would be posted again.
+	SeenAt    time.Time `gorm:"index:idx_rss_entries_seen"`
+	CreatedAt time.Time
+	// Updated marks an entry already delivered whose content changed, it is not saved
+	Updated bool `gorm:"-"`
+}
+
+// Author is a person credited with an entry.
+type Author struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Enclosure is a file attached to an entry, such as the audio of a podcast episode.
+// Length is its size in bytes, zero when the feed does not tell it.
+type Enclosure struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+// Media is a media:content or media:thumbnail of an entry.
+type Media struct {
+	URL string `json:"url"`
+	// Type is the MIME type and Medium the kind of media, such as image or video
+	Type      string `json:"type,omitempty"`
+	Medium    string `json:"medium,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Thumbnail bool   `json:"thumbnail,omitempty"`
 }
@@ -4,9 +4,34 @@ import (
 	"time"
 )
 
+// Status of a subscription
+const (
+	SubscriptionActive = "active"
+	// SubscriptionBroken marks a subscription whose feed keeps failing, until it recovers
+	SubscriptionBroken = "broken"
+	// SubscriptionDisabled marks a subscription whose feed is gone, it is no longer polled
+	SubscriptionDisabled = "disabled"
+)
+
+// What a subscription does when an entry already posted changes
+const (
+	// UpdateEdit edits the message the entry was posted as
+	UpdateEdit = "edit"
+	// UpdateRepost posts the entry again
+	UpdateRepost = "repost"
+	// UpdateIgnore leaves the message as it is
+	UpdateIgnore = "ignore"
+)
+
 type Subscription struct {
-	ID        uint `gorm:"primaryKey"`
-	ChannelID string
-	RSSURL    string
+	ID        uint   `gorm:"primaryKey"`
+	ChannelID string `gorm:"uniqueIndex:idx_subscriptions_channel_feed"`
+	FeedID    uint   `gorm:"uniqueIndex:idx_subscriptions_channel_feed"`
+	Feed      Feed
+	// Interval is how often the feed is polled for this subscription, zero for the default
+	Interval time.Duration
+	Status   string `gorm:"default:active"`
+	// OnUpdate is what to do when an entry already posted changes, UpdateEdit by default
+	OnUpdate  string `gorm:"not null;default:edit"`
 	CreatedAt time.Time
 }
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+type DeliveryRepository interface {
+	// Find returns the delivery of the entry to the subscription, ErrNotFound when it was never posted
+	Find(subscriptionID, entryID uint) (model.Delivery, error)
+	// Save creates the delivery of its entry to its subscription, or replaces the state of an earlier one
+	Save(delivery model.Delivery) (model.Delivery, error)
+	// FindDue returns the pending and failed deliveries due at now with fewer than maxAttempts attempts, oldest first
+	FindDue(now time.Time, maxAttempts int) ([]model.Delivery, error)
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrFeedNotFound = errors.New("feed not found")
+
+type DiscoveredFeed struct {
+	URL   string
+	Title string
+}
+
+type FeedDiscoverer interface {
+	// Discover returns the feeds found at pageURL, which is either a feed itself or a web page linking to feeds.
+	Discover(ctx context.Context, pageURL string) ([]DiscoveredFeed, error)
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type FeedRepository interface {
+	Find(id uint) (model.Feed, error)
+	// FindBySource finds the feed with the URL, selector and options of feed,
+	// zero selector and options finding the plain feed at the URL
+	FindBySource(feed model.Feed) (model.Feed, error)
+	FindOrCreate(feed model.Feed) (model.Feed, error)
+	// Save updates the fetch state of feeds, leaving their WebSub state as stored
+	Save(feeds []model.Feed) error
+	// UpdateWebSub updates the WebSub state of feed only
+	UpdateWebSub(feed model.Feed) error
+	// UpdateDocument updates what a document pushed for feed tells only: its title, site link, icon and repairs
+	UpdateDocument(feed model.Feed) error
+	// Merge moves the subscriptions and the entries of the feed from to the feed into, then deletes from.
+	// A subscription whose channel already subscribes to into is deleted.
+	Merge(from, into uint) error
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrUnsupportedShorthand = errors.New("unsupported shorthand")
+
+type SourceResolver interface {
+	// Resolve returns the feed URL of a shorthand such as github:owner/repo/releases,
+	// and source unchanged when it is not a shorthand.
+	Resolve(ctx context.Context, source string) (string, error)
+}
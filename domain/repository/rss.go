@@ -1,9 +1,44 @@
 package repository
 
 import (
+	"context"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/mmcdole/gofeed"
 )
 
+// FetchResult holds the feed metadata, the parsed items and the cache validators returned by the server.
+// NotModified is true when the server answered 304 and only the validators and the headers hints are set.
+type FetchResult struct {
+	Title        string
+	SiteLink     string
+	IconURL      string
+	Items        []*gofeed.Item
+	ETag         string
+	LastModified string
+	NotModified  bool
+	// MovedTo is the URL the feed was permanently redirected to, empty when it was not
+	MovedTo string
+	// HubURL and SelfURL are the WebSub hub and topic advertised by the feed
+	HubURL  string
+	SelfURL string
+	// TTL, SkipHours and SkipDays are the polling hints of an RSS channel
+	TTL       time.Duration
+	SkipHours []int
+	SkipDays  []time.Weekday
+	// MaxAge and RetryAfter come from the Cache-Control and Retry-After headers
+	MaxAge     time.Duration
+	RetryAfter time.Duration
+	// Repairs lists what the malformed document needed fixed to be parsed, empty when it was well-formed
+	Repairs []string
+}
+
 type RssFetcher interface {
-	Fetch(rssURL string) ([]*gofeed.Item, error)
+	Fetch(ctx context.Context, feed model.Feed) (FetchResult, error)
+}
+
+// FeedParser parses a feed document received rather than fetched.
+type FeedParser interface {
+	ParseFeed(body []byte) (FetchResult, error)
 }
@@ -1,8 +1,27 @@
 package repository
 
-import "github.com/dev-shimada/discord-rss-bot/domain/model"
+import (
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
 
 type RssEnrtyRepository interface {
 	Create(entries []model.RssEntry) error
-	Find(entries []model.RssEntry) []model.RssEntry
+	// FindByIdentities returns the entries of the feed with any of identities
+	FindByIdentities(feedID uint, identities []string) ([]model.RssEntry, error)
+	FindByIDs(ids []uint) ([]model.RssEntry, error)
+	// Save creates the new entries and updates the title, link and content hash of the changed ones,
+	// queuing deliveries in the same transaction so that no entry is ever seen without being delivered.
+	// A delivery refers to its entry through Entry, as a new entry has no ID before it is created.
+	// A delivery already sent is queued again, one not sent yet is left to send the entry as saved.
+	Save(created, changed []model.RssEntry, deliveries []model.Delivery) error
+	// MarkSeen sets the time the entries with the identities of each feed were last seen in it
+	MarkSeen(identities map[uint][]string, at time.Time) error
+	// Prune deletes the entries first saved before the given time, and those past the latest keep of their feed,
+	// but never the live ones nor those with a delivery not sent yet and still to be attempted before maxAttempts.
+	// It returns how many it deleted.
+	Prune(before time.Time, keep, maxAttempts int) (int, error)
+	// RecentPublishedAt returns the publication times of the latest n entries of each feed, newest first
+	RecentPublishedAt(feedIDs []uint, n int) (map[uint][]time.Time, error)
 }
@@ -1,6 +1,12 @@
 package repository
 
-import "github.com/dev-shimada/discord-rss-bot/domain/model"
+import (
+	"errors"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+var ErrAlreadyExists = errors.New("already exists")
 
 type SubscriptionRepository interface {
 	Create(sub model.Subscription) error
@@ -8,4 +14,5 @@ type SubscriptionRepository interface {
 	FindByModel(m model.Subscription) ([]model.Subscription, error)
 	FindAll() ([]model.Subscription, error)
 	Delete(m model.Subscription) error
+	UpdateStatus(ids []uint, status string) error
 }
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidSignature is returned for pushed content not signed with the secret of the subscription
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// HubRequest is a WebSub subscription request.
+type HubRequest struct {
+	// Mode is subscribe or unsubscribe
+	Mode     string
+	Topic    string
+	Callback string
+	Secret   string
+	Lease    time.Duration
+}
+
+type HubClient interface {
+	Subscribe(ctx context.Context, hub string, req HubRequest) error
+}
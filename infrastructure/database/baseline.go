@@ -0,0 +1,92 @@
+package database
+
+import (
+	"time"
+)
+
+// The tables as of the baseline migration. Migrations hold their own copy of the tables they build
+// instead of using the models, so that they keep building the same schema whatever the models become.
+// MySQL indexes the text columns by a prefix, whose lengths keep the keys within its limit of 3072 bytes.
+// Subscriptions reference their feed without a foreign key, as SQLite never enforced it.
+
+type baselineFeed struct {
+	ID                  uint             `gorm:"primaryKey"`
+	URL                 string           `gorm:"uniqueIndex:idx_feeds_source,length:255"`
+	Selector            baselineSelector `gorm:"embedded;embeddedPrefix:selector_"`
+	Options             string
+	OptionsDigest       string `gorm:"uniqueIndex:idx_feeds_source,length:64;not null;default:''"`
+	Title               string
+	SiteLink            string
+	IconURL             string
+	ETag                string
+	LastModified        string
+	TTL                 time.Duration
+	SkipHours           string
+	SkipDays            string
+	ConsecutiveFailures int
+	LastError           string
+	LastSuccessAt       time.Time
+	Repairs             string
+	ConsecutiveNotFound int
+	HubURL              string
+	TopicURL            string
+	WebSubSecret        string
+	WebSubRequestedAt   time.Time
+	WebSubExpiresAt     time.Time
+	LastCheckedAt       time.Time
+	NextDueAt           time.Time `gorm:"index"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (baselineFeed) TableName() string { return "feeds" }
+
+type baselineSelector struct {
+	Item  string `gorm:"uniqueIndex:idx_feeds_source,length:100;not null;default:''"`
+	Title string `gorm:"uniqueIndex:idx_feeds_source,length:100;not null;default:''"`
+	Link  string `gorm:"uniqueIndex:idx_feeds_source,length:100;not null;default:''"`
+	Date  string `gorm:"uniqueIndex:idx_feeds_source,length:100;not null;default:''"`
+}
+
+type baselineSubscription struct {
+	ID        uint   `gorm:"primaryKey"`
+	ChannelID string `gorm:"uniqueIndex:idx_subscriptions_channel_feed,length:32"`
+	FeedID    uint   `gorm:"uniqueIndex:idx_subscriptions_channel_feed"`
+	Interval  time.Duration
+	Status    string `gorm:"default:active"`
+	OnUpdate  string `gorm:"not null;default:edit"`
+	CreatedAt time.Time
+}
+
+func (baselineSubscription) TableName() string { return "subscriptions" }
+
+type baselineRssEntry struct {
+	ID          uint   `gorm:"primaryKey"`
+	FeedID      uint   `gorm:"index;uniqueIndex:idx_rss_entries_identity;index:idx_rss_entries_seen"`
+	Identity    string `gorm:"size:2048;uniqueIndex:idx_rss_entries_identity,length:700;not null;default:''"`
+	EntryTitle  string
+	EntryLink   string
+	PublishedAt time.Time
+	ContentHash string    `gorm:"not null;default:''"`
+	SeenAt      time.Time `gorm:"index:idx_rss_entries_seen"`
+	CreatedAt   time.Time
+}
+
+func (baselineRssEntry) TableName() string { return "rss_entries" }
+
+type baselineDelivery struct {
+	ID             uint   `gorm:"primaryKey"`
+	SubscriptionID uint   `gorm:"uniqueIndex:idx_deliveries_subscription_entry"`
+	EntryID        uint   `gorm:"uniqueIndex:idx_deliveries_subscription_entry"`
+	Status         string `gorm:"index:idx_deliveries_due;not null;default:sent"`
+	Action         string `gorm:"not null;default:post"`
+	Nonce          string
+	Attempts       int
+	LastError      string
+	MessageID      string
+	NextAttemptAt  time.Time `gorm:"index:idx_deliveries_due"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (baselineDelivery) TableName() string { return "deliveries" }
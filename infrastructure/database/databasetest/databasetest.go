@@ -0,0 +1,46 @@
+// Package databasetest provides the databases the tests of the repositories run against.
+package databasetest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"gorm.io/gorm"
+)
+
+// NewDB returns an empty database migrated to the latest version, to be closed with database.CloseDB.
+// It is the SQLite file testdata/test.db of the package under test, unless TEST_DATABASE_URL gives another database,
+// such as postgres://postgres@localhost:5432/test, whose tables are dropped first.
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	u := os.Getenv("TEST_DATABASE_URL")
+	if u == "" {
+		os.Remove("testdata/test.db")
+		u = "sqlite:testdata/test.db"
+	}
+	db, err := database.Open(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if err := db.Migrator().DropTable(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Now returns the current time to the microsecond, the precision the databases keep,
+// so that a time compares equal once read back.
+func Now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("keyhash", keyHashSerializer{})
+}
+
+// KeyHash returns the hash of a key indexed through its hash, empty for an empty key.
+func KeyHash(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyHashSerializer stores the hash of the key a field is named after, such as URL for URLHash,
+// so that a unique index holds the hash of a key too long for MySQL to index whole instead of a prefix of it,
+// which two keys could share. The hash is computed whenever the model is written and never read back.
+type keyHashSerializer struct{}
+
+func (keyHashSerializer) Scan(_ context.Context, _ *schema.Field, _ reflect.Value, _ any) error {
+	return nil
+}
+
+func (keyHashSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, _ any) (any, error) {
+	key := field.Schema.LookUpField(strings.TrimSuffix(field.Name, "Hash"))
+	if key == nil {
+		return nil, fmt.Errorf("no key hashed by %s", field.Name)
+	}
+	v, _ := key.ValueOf(ctx, dst)
+	s, _ := v.(string)
+	return KeyHash(s), nil
+}
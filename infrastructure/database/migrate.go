@@ -0,0 +1,207 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// dedupeSubscriptions removes duplicated subscriptions created before the unique index existed,
+// keeping the oldest one.
+func dedupeSubscriptions(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("subscriptions", "rss_url") {
+		return nil
+	}
+	return db.Exec("DELETE FROM subscriptions WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM subscriptions GROUP BY channel_id, rss_url) AS kept)").Error
+}
+
+// migrateFeeds converts the URL stored on every subscription and entry into a reference to a feed,
+// carrying over the validators of feed_caches.
+func migrateFeeds(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("subscriptions", "rss_url") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.AutoMigrate(&baselineFeed{}); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		urls := "SELECT rss_url FROM subscriptions"
+		if m.HasTable("rss_entries") && m.HasColumn("rss_entries", "rss_url") {
+			// keep the history of feeds no longer subscribed, too
+			urls += " UNION SELECT rss_url FROM rss_entries"
+		}
+		if err := tx.Exec("INSERT INTO feeds (url, created_at, updated_at) SELECT rss_url, ?, ? FROM ("+urls+") AS urls WHERE rss_url NOT IN (SELECT url FROM feeds)", now, now).Error; err != nil {
+			return err
+		}
+		if m.HasTable("feed_caches") {
+			if err := tx.Exec(`UPDATE feeds SET
+				e_tag = (SELECT e_tag FROM feed_caches WHERE feed_caches.rss_url = feeds.url),
+				last_modified = (SELECT last_modified FROM feed_caches WHERE feed_caches.rss_url = feeds.url)
+				WHERE url IN (SELECT rss_url FROM feed_caches)`).Error; err != nil {
+				return err
+			}
+			if err := m.DropTable("feed_caches"); err != nil {
+				return err
+			}
+		}
+
+		if err := m.AddColumn(&baselineSubscription{}, "FeedID"); err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE subscriptions SET feed_id = (SELECT id FROM feeds WHERE feeds.url = subscriptions.rss_url)").Error; err != nil {
+			return err
+		}
+		if m.HasIndex("subscriptions", "idx_subscriptions_channel_rss") {
+			if err := m.DropIndex("subscriptions", "idx_subscriptions_channel_rss"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("ALTER TABLE subscriptions DROP COLUMN rss_url").Error; err != nil {
+			return err
+		}
+
+		if !m.HasTable("rss_entries") || !m.HasColumn("rss_entries", "rss_url") {
+			return nil
+		}
+		if err := m.AddColumn(&baselineRssEntry{}, "FeedID"); err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE rss_entries SET feed_id = (SELECT id FROM feeds WHERE feeds.url = rss_entries.rss_url)").Error; err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE rss_entries DROP COLUMN rss_url").Error
+	})
+}
+
+// dropFeedURLIndex drops the unique index on the URL of feeds, replaced by one on the URL and the selector
+// so that a page can be scraped with several selectors.
+func dropFeedURLIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex("feeds", "idx_feeds_url") {
+		return nil
+	}
+	return db.Migrator().DropIndex("feeds", "idx_feeds_url")
+}
+
+// dropFeedSourceIndex drops the unique index on the source of feeds created before the options of a feed
+// were part of it, so that the baseline builds it again with the digest of the options.
+func dropFeedSourceIndex(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasIndex("feeds", "idx_feeds_source") || m.HasColumn("feeds", "options_digest") {
+		return nil
+	}
+	return m.DropIndex("feeds", "idx_feeds_source")
+}
+
+// backfillEntryIdentity keys the entries saved before they had an identity by their link, the key they had until then,
+// and removes the duplicates a feed could hold, so that the unique index on the identity can be built.
+func backfillEntryIdentity(db *gorm.DB) error {
+	if !db.Migrator().HasTable("rss_entries") || db.Migrator().HasColumn("rss_entries", "identity") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&baselineRssEntry{}, "Identity"); err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE rss_entries SET identity = CASE WHEN entry_link <> '' THEN CONCAT('legacy:', entry_link) ELSE CONCAT('legacy-id:', id) END").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM rss_entries WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM rss_entries GROUP BY feed_id, identity) AS kept)").Error
+	})
+}
+
+// entryContent holds the columns addEntryContent adds to the entries, the lists being stored as JSON
+type entryContent struct {
+	Description string
+	Content     string
+	Authors     string
+	Categories  string
+	Enclosures  string
+	ImageURL    string
+	Media       string
+}
+
+func (entryContent) TableName() string { return "rss_entries" }
+
+// addEntryContent adds the summary, the content, the authors, the categories, the enclosures and the media
+// of the entries, empty for the entries saved before.
+func addEntryContent(db *gorm.DB) error {
+	return db.Migrator().AutoMigrate(&entryContent{})
+}
+
+// feedLiveAt holds the column addFeedLiveAt adds to the feeds
+type feedLiveAt struct {
+	LiveAt time.Time
+}
+
+func (feedLiveAt) TableName() string { return "feeds" }
+
+// addFeedLiveAt adds when the whole document of a feed was last read, taken as the time its entries were last seen,
+// which told the live entries until then.
+func addFeedLiveAt(db *gorm.DB) error {
+	if err := db.Migrator().AutoMigrate(&feedLiveAt{}); err != nil {
+		return err
+	}
+	return db.Exec("UPDATE feeds SET live_at = (SELECT MAX(seen_at) FROM rss_entries WHERE rss_entries.feed_id = feeds.id) " +
+		"WHERE EXISTS (SELECT 1 FROM rss_entries WHERE rss_entries.feed_id = feeds.id)").Error
+}
+
+// feedSourceV9 is the source of a feed as indexed by indexKeyHashes
+type feedSourceV9 struct {
+	URLHash  string `gorm:"size:64;uniqueIndex:idx_feeds_source;not null;default:''"`
+	Selector struct {
+		Item  string `gorm:"uniqueIndex:idx_feeds_source,length:100"`
+		Title string `gorm:"uniqueIndex:idx_feeds_source,length:100"`
+		Link  string `gorm:"uniqueIndex:idx_feeds_source,length:100"`
+		Date  string `gorm:"uniqueIndex:idx_feeds_source,length:100"`
+	} `gorm:"embedded;embeddedPrefix:selector_"`
+	OptionsDigest string `gorm:"uniqueIndex:idx_feeds_source,length:64"`
+}
+
+func (feedSourceV9) TableName() string { return "feeds" }
+
+// entryIdentityV9 is the identity of an entry as indexed by indexKeyHashes
+type entryIdentityV9 struct {
+	FeedID       uint   `gorm:"uniqueIndex:idx_rss_entries_identity"`
+	IdentityHash string `gorm:"size:64;uniqueIndex:idx_rss_entries_identity;not null;default:''"`
+}
+
+func (entryIdentityV9) TableName() string { return "rss_entries" }
+
+// indexKeyHashes replaces the URL of the feeds and the identity of the entries in their unique indexes by their hashes,
+// as MySQL only indexed a prefix of them, which two of them could share.
+// The hashes are computed here, as SQLite has no SQL function for them.
+func indexKeyHashes(db *gorm.DB) error {
+	for _, k := range []struct {
+		model                 any
+		table, key, hash, idx string
+	}{
+		{model: &feedSourceV9{}, table: "feeds", key: "url", hash: "url_hash", idx: "idx_feeds_source"},
+		{model: &entryIdentityV9{}, table: "rss_entries", key: "identity", hash: "identity_hash", idx: "idx_rss_entries_identity"},
+	} {
+		if err := db.Migrator().AddColumn(k.model, k.hash); err != nil {
+			return err
+		}
+		var rows []struct {
+			ID       uint
+			KeyValue string
+		}
+		if err := db.Table(k.table).Select("id, " + k.key + " AS key_value").Where(k.key + " IS NOT NULL").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := db.Table(k.table).Where("id = ?", r.ID).Update(k.hash, KeyHash(r.KeyValue)).Error; err != nil {
+				return err
+			}
+		}
+		if err := db.Migrator().DropIndex(k.table, k.idx); err != nil {
+			return err
+		}
+		if err := db.Migrator().CreateIndex(k.model, k.idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
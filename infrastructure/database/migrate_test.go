@@ -0,0 +1,638 @@
+package database_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// schema before feeds were introduced
+type subscriptionV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	ChannelID string `gorm:"uniqueIndex:idx_subscriptions_channel_rss"`
+	RSSURL    string `gorm:"uniqueIndex:idx_subscriptions_channel_rss"`
+	CreatedAt time.Time
+}
+
+func (subscriptionV1) TableName() string { return "subscriptions" }
+
+type rssEntryV1 struct {
+	ID          uint `gorm:"primaryKey"`
+	RSSURL      string
+	EntryTitle  string
+	EntryLink   string
+	PublishedAt time.Time
+	CreatedAt   time.Time
+}
+
+func (rssEntryV1) TableName() string { return "rss_entries" }
+
+type feedCacheV1 struct {
+	ID           uint   `gorm:"primaryKey"`
+	RSSURL       string `gorm:"uniqueIndex"`
+	ETag         string
+	LastModified string
+	UpdatedAt    time.Time
+}
+
+func (feedCacheV1) TableName() string { return "feed_caches" }
+
+func TestMigrateFeeds(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.AutoMigrate(&subscriptionV1{}, &rssEntryV1{}, &feedCacheV1{}); err != nil {
+		t.Fatal(err)
+	}
+	old.Create(&[]subscriptionV1{
+		{ChannelID: "1", RSSURL: "https://a.example.com/"},
+		{ChannelID: "2", RSSURL: "https://a.example.com/"},
+		{ChannelID: "1", RSSURL: "https://b.example.com/"},
+	})
+	old.Create(&[]rssEntryV1{
+		{RSSURL: "https://a.example.com/", EntryLink: "https://a.example.com/1"},
+		{RSSURL: "https://c.example.com/", EntryLink: "https://c.example.com/1"},
+	})
+	old.Create(&feedCacheV1{RSSURL: "https://a.example.com/", ETag: `"a"`})
+	database.CloseDB(old)
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+
+	// assert
+	type feed struct {
+		URL  string
+		ETag string
+	}
+	gotFeeds := []feed{}
+	db.Model(&model.Feed{}).Order("url").Find(&gotFeeds)
+	wantFeeds := []feed{
+		{URL: "https://a.example.com/", ETag: `"a"`},
+		{URL: "https://b.example.com/"},
+		{URL: "https://c.example.com/"},
+	}
+	if !cmp.Equal(gotFeeds, wantFeeds) {
+		t.Errorf("Diff: %v", cmp.Diff(gotFeeds, wantFeeds))
+	}
+
+	type subscription struct {
+		ChannelID string
+		URL       string
+	}
+	gotSubs := []subscription{}
+	db.Table("subscriptions").Select("subscriptions.channel_id, feeds.url").Joins("JOIN feeds ON feeds.id = subscriptions.feed_id").Order("subscriptions.id").Find(&gotSubs)
+	wantSubs := []subscription{
+		{ChannelID: "1", URL: "https://a.example.com/"},
+		{ChannelID: "2", URL: "https://a.example.com/"},
+		{ChannelID: "1", URL: "https://b.example.com/"},
+	}
+	if !cmp.Equal(gotSubs, wantSubs) {
+		t.Errorf("Diff: %v", cmp.Diff(gotSubs, wantSubs))
+	}
+
+	type entry struct {
+		EntryLink string
+		URL       string
+	}
+	gotEntries := []entry{}
+	db.Table("rss_entries").Select("rss_entries.entry_link, feeds.url").Joins("JOIN feeds ON feeds.id = rss_entries.feed_id").Order("rss_entries.id").Find(&gotEntries)
+	wantEntries := []entry{
+		{EntryLink: "https://a.example.com/1", URL: "https://a.example.com/"},
+		{EntryLink: "https://c.example.com/1", URL: "https://c.example.com/"},
+	}
+	if !cmp.Equal(gotEntries, wantEntries) {
+		t.Errorf("Diff: %v", cmp.Diff(gotEntries, wantEntries))
+	}
+
+	if db.Migrator().HasTable("feed_caches") {
+		t.Errorf("want: feed_caches dropped")
+	}
+	if db.Migrator().HasColumn("subscriptions", "rss_url") || db.Migrator().HasColumn("rss_entries", "rss_url") {
+		t.Errorf("want: rss_url dropped")
+	}
+}
+
+// schema before scraped pages were introduced
+type feedV1 struct {
+	ID  uint   `gorm:"primaryKey"`
+	URL string `gorm:"uniqueIndex"`
+}
+
+func (feedV1) TableName() string { return "feeds" }
+
+func TestDropFeedURLIndex(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.AutoMigrate(&feedV1{}); err != nil {
+		t.Fatal(err)
+	}
+	old.Create(&feedV1{URL: "https://a.example.com/"})
+	database.CloseDB(old)
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	err = db.Create(&model.Feed{URL: "https://a.example.com/", Selector: model.Selector{Item: "article"}}).Error
+
+	// assert
+	if err != nil {
+		t.Errorf("want: nil, got: %v", err)
+	}
+	if db.Migrator().HasIndex("feeds", "idx_feeds_url") || !db.Migrator().HasIndex("feeds", "idx_feeds_source") {
+		t.Errorf("want: idx_feeds_url replaced by idx_feeds_source")
+	}
+	if err := db.Create(&model.Feed{URL: "https://a.example.com/"}).Error; err == nil {
+		t.Errorf("want: duplicated feed rejected, got: nil")
+	}
+}
+
+// schema before the options of a feed were part of its source
+type feedV2 struct {
+	ID           uint   `gorm:"primaryKey"`
+	URL          string `gorm:"uniqueIndex:idx_feeds_source"`
+	SelectorItem string `gorm:"uniqueIndex:idx_feeds_source;not null;default:''"`
+}
+
+func (feedV2) TableName() string { return "feeds" }
+
+func TestDropFeedSourceIndex(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.AutoMigrate(&feedV2{}); err != nil {
+		t.Fatal(err)
+	}
+	old.Create(&feedV2{URL: "https://a.example.com/"})
+	database.CloseDB(old)
+	if err := database.SetEncryptionKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	err = db.Create(&model.Feed{URL: "https://a.example.com/", Options: model.FetchOptions{Token: "secret"}, OptionsDigest: "digest"}).Error
+
+	// assert
+	if err != nil {
+		t.Errorf("want: nil, got: %v", err)
+	}
+	if err := db.Create(&model.Feed{URL: "https://a.example.com/"}).Error; err == nil {
+		t.Errorf("want: duplicated feed rejected, got: nil")
+	}
+}
+
+// schema before entries had an identity
+type rssEntryV2 struct {
+	ID         uint `gorm:"primaryKey"`
+	FeedID     uint `gorm:"index"`
+	EntryTitle string
+	EntryLink  string
+}
+
+func (rssEntryV2) TableName() string { return "rss_entries" }
+
+func TestBackfillEntryIdentity(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.AutoMigrate(&rssEntryV2{}); err != nil {
+		t.Fatal(err)
+	}
+	old.Create(&[]rssEntryV2{
+		{FeedID: 1, EntryTitle: "title1", EntryLink: "https://example.com/1"},
+		{FeedID: 1, EntryTitle: "title1 again", EntryLink: "https://example.com/1"},
+		{FeedID: 2, EntryTitle: "title1", EntryLink: "https://example.com/1"},
+		{FeedID: 1, EntryTitle: "no link"},
+	})
+	database.CloseDB(old)
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	got := []model.RssEntry{}
+	db.Order("id").Find(&got)
+
+	// assert
+	want := []model.RssEntry{
+		{ID: 1, FeedID: 1, Identity: "legacy:https://example.com/1", EntryTitle: "title1", EntryLink: "https://example.com/1"},
+		{ID: 3, FeedID: 2, Identity: "legacy:https://example.com/1", EntryTitle: "title1", EntryLink: "https://example.com/1"},
+		{ID: 4, FeedID: 1, Identity: "legacy-id:4", EntryTitle: "no link"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	if err := db.Create(&model.RssEntry{FeedID: 1, Identity: "legacy:https://example.com/1"}).Error; err == nil {
+		t.Errorf("want: duplicated identity rejected, got: nil")
+	}
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	// the databases migrated by the tests are backed up beside them
+	backups, _ := filepath.Glob("testdata/*.bak")
+	for _, b := range backups {
+		os.Remove(b)
+	}
+	os.Exit(code)
+}
+
+// counts returns the number of rows of every table but the bookkeeping ones.
+func counts(t *testing.T, db *gorm.DB) map[string]int64 {
+	t.Helper()
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, table := range tables {
+		if table == "sqlite_sequence" || table == "schema_migrations" {
+			continue
+		}
+		var n int64
+		db.Table(table).Count(&n)
+		got[table] = n
+	}
+	return got
+}
+
+// schemaOf returns the columns and the indexes of every table.
+func schemaOf(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	var rows []struct {
+		Tbl  string
+		Name string
+	}
+	if err := db.Raw(`SELECT m.name AS tbl, 'column ' || p.name AS name FROM sqlite_master m JOIN pragma_table_info(m.name) p WHERE m.type = 'table'
+		UNION SELECT tbl_name AS tbl, 'index ' || name AS name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL`).Scan(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, r := range rows {
+		got[r.Tbl] = append(got[r.Tbl], r.Name)
+	}
+	for table := range got {
+		slices.Sort(got[table])
+	}
+	return got
+}
+
+func TestBackfillFeedLiveAt(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := os.ReadFile("testdata/snapshots/entries_without_content.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Exec(string(dump)).Error; err != nil {
+		t.Fatal(err)
+	}
+	old.Exec("INSERT INTO feeds (id, url, created_at, updated_at) VALUES (2, 'https://b.example.com/', '2024-01-01 00:00:00', '2024-01-01 00:00:00')")
+	database.CloseDB(old)
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	feeds := []model.Feed{}
+	db.Order("id").Find(&feeds)
+
+	// assert
+	// the entries were last seen when the document of their feed was last read
+	if len(feeds) != 2 || !feeds[0].LiveAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !feeds[1].LiveAt.IsZero() {
+		t.Errorf("want: the live time of feed 1 only, got: %v", feeds)
+	}
+}
+
+func TestBackfillKeyHashes(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := os.ReadFile("testdata/snapshots/entries_without_content.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Exec(string(dump)).Error; err != nil {
+		t.Fatal(err)
+	}
+	database.CloseDB(old)
+
+	// test
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	type keys struct {
+		Key  string
+		Hash string
+	}
+	feeds := []keys{}
+	db.Raw("SELECT url AS key, url_hash AS hash FROM feeds").Scan(&feeds)
+	entries := []keys{}
+	db.Raw("SELECT identity AS key, identity_hash AS hash FROM rss_entries").Scan(&entries)
+
+	// assert
+	if len(feeds) == 0 || len(entries) == 0 {
+		t.Fatalf("want: feeds and entries, got: %v, %v", feeds, entries)
+	}
+	for _, k := range append(feeds, entries...) {
+		if k.Hash != database.KeyHash(k.Key) {
+			t.Errorf("want: %q hashed, got: %q", k.Key, k.Hash)
+		}
+	}
+}
+
+func TestMigrateSnapshots(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Setenv("DB_PATH", "testdata/fresh.db")
+	os.Remove("testdata/fresh.db")
+	fresh := database.NewDB()
+	if fresh == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	wantSchema := schemaOf(t, fresh)
+	database.CloseDB(fresh)
+	os.Setenv("DB_PATH", "testdata/test.db")
+
+	tests := []struct {
+		name     string
+		snapshot string
+		version  int
+		want     map[string]int64
+	}{
+		{
+			name:     "before feeds",
+			snapshot: "rss_url.sql",
+			want:     map[string]int64{"feeds": 3, "subscriptions": 2, "rss_entries": 2, "deliveries": 0},
+		},
+		{
+			name:     "before scraped pages",
+			snapshot: "feed_url_index.sql",
+			want:     map[string]int64{"feeds": 2, "subscriptions": 2, "rss_entries": 2, "deliveries": 0},
+		},
+		{
+			name:     "before feed options",
+			snapshot: "feed_source_index.sql",
+			want:     map[string]int64{"feeds": 2, "subscriptions": 2, "rss_entries": 1, "deliveries": 0},
+		},
+		{
+			name:     "before entry identities",
+			snapshot: "entries_without_identity.sql",
+			want:     map[string]int64{"feeds": 1, "subscriptions": 1, "rss_entries": 2, "deliveries": 0},
+		},
+		{
+			name:     "before versioned migrations",
+			snapshot: "unversioned.sql",
+			want:     map[string]int64{"feeds": 1, "subscriptions": 1, "rss_entries": 1, "deliveries": 1},
+		},
+		{
+			name:     "before entry content",
+			snapshot: "entries_without_content.sql",
+			version:  6,
+			want:     map[string]int64{"feeds": 1, "subscriptions": 1, "rss_entries": 1, "deliveries": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare
+			os.Remove("testdata/test.db")
+			backups, _ := filepath.Glob("testdata/test.db.*.bak")
+			for _, b := range backups {
+				os.Remove(b)
+			}
+			dump, err := os.ReadFile(filepath.Join("testdata/snapshots", tt.snapshot))
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, err := gorm.Open(sqlite.Open("testdata/test.db"), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := old.Exec(string(dump)).Error; err != nil {
+				t.Fatal(err)
+			}
+			wantBackup := counts(t, old)
+			database.CloseDB(old)
+
+			// test
+			db := database.NewDB()
+			if db == nil {
+				t.Fatal("want: db, got: nil")
+			}
+			defer database.CloseDB(db)
+
+			// assert
+			if diff := cmp.Diff(counts(t, db), tt.want); diff != "" {
+				t.Errorf("rows Diff: %v", diff)
+			}
+			if diff := cmp.Diff(schemaOf(t, db), wantSchema); diff != "" {
+				t.Errorf("schema Diff: %v", diff)
+			}
+			status, err := database.Status(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range status {
+				if s.Pending() {
+					t.Errorf("want: migration %d %s applied", s.Version, s.Name)
+				}
+			}
+			backups, _ = filepath.Glob(fmt.Sprintf("testdata/test.db.v%d-*.bak", tt.version))
+			if len(backups) != 1 {
+				t.Fatalf("want: 1 backup, got: %v", backups)
+			}
+			backup, err := gorm.Open(sqlite.Open(backups[0]), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.CloseDB(backup)
+			if diff := cmp.Diff(counts(t, backup), wantBackup); diff != "" {
+				t.Errorf("backup Diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	want := []string{"1 dedupe_subscriptions", "2 feeds", "3 drop_feed_url_index", "4 drop_feed_source_index", "5 entry_identity", "6 baseline", "7 entry_content", "8 feed_live_at", "9 key_hashes"}
+	tests := []struct {
+		name        string
+		migrate     bool
+		wantPending bool
+	}{
+		{name: "new database", migrate: false, wantPending: true},
+		{name: "migrated", migrate: true, wantPending: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare
+			os.Remove("testdata/test.db")
+			backups, _ := filepath.Glob("testdata/test.db.*.bak")
+			for _, b := range backups {
+				os.Remove(b)
+			}
+			var db *gorm.DB
+			if tt.migrate {
+				db = database.NewDB()
+			} else {
+				db = database.OpenDB()
+			}
+			if db == nil {
+				t.Fatal("want: db, got: nil")
+			}
+			defer database.CloseDB(db)
+
+			// test
+			status, err := database.Status(db)
+
+			// assert
+			if err != nil {
+				t.Fatalf("want: nil, got: %v", err)
+			}
+			got := []string{}
+			for _, s := range status {
+				got = append(got, fmt.Sprintf("%d %s", s.Version, s.Name))
+				if s.Pending() != tt.wantPending {
+					t.Errorf("migration %d, want pending: %v, got: %v", s.Version, tt.wantPending, s.Pending())
+				}
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+			if !tt.migrate && db.Migrator().HasTable("schema_migrations") {
+				t.Errorf("want: status without creating schema_migrations")
+			}
+			if backups, _ := filepath.Glob("testdata/test.db.*.bak"); len(backups) != 0 {
+				t.Errorf("want: new database not backed up, got: %v", backups)
+			}
+		})
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	bfDbPath := os.Getenv("DB_PATH")
+	os.Setenv("DB_PATH", "testdata/test.db")
+	defer os.Setenv("DB_PATH", bfDbPath)
+
+	// setup
+	os.Remove("testdata/test.db")
+	db := database.NewDB()
+	if db == nil {
+		t.Fatal("want: db, got: nil")
+	}
+	defer database.CloseDB(db)
+	if err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'future', ?)", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// test
+	err := database.Migrate(db)
+
+	// assert
+	if err == nil {
+		t.Errorf("want: error, got: nil")
+	}
+}
+
+func TestMigrationsCoverModels(t *testing.T) {
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+
+	for _, m := range []any{&model.Feed{}, &model.Subscription{}, &model.RssEntry{}, &model.Delivery{}} {
+		// test
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			t.Fatal(err)
+		}
+
+		// assert
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName != "" && !db.Migrator().HasColumn(m, f.DBName) {
+				t.Errorf("want: a migration adding %s.%s", stmt.Schema.Table, f.DBName)
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !db.Migrator().HasIndex(m, idx.Name) {
+				t.Errorf("want: a migration adding %s", idx.Name)
+			}
+		}
+	}
+}
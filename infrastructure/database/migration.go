@@ -0,0 +1,174 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migration is a change of the schema, applied once and in the order of its version.
+// A migration is never edited once released, a change of the models gets a migration of its own.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations are the changes of the schema in order. The first ones bring the databases created before
+// migrations were versioned up to the baseline, and do nothing on the others.
+// Their statements stick to the SQL every database understands, such as a derived table for MySQL
+// to select from the table it deletes from.
+var migrations = []migration{
+	{version: 1, name: "dedupe_subscriptions", up: dedupeSubscriptions},
+	{version: 2, name: "feeds", up: migrateFeeds},
+	{version: 3, name: "drop_feed_url_index", up: dropFeedURLIndex},
+	{version: 4, name: "drop_feed_source_index", up: dropFeedSourceIndex},
+	{version: 5, name: "entry_identity", up: backfillEntryIdentity},
+	{version: 6, name: "baseline", up: func(tx *gorm.DB) error {
+		return tx.Migrator().AutoMigrate(&baselineFeed{}, &baselineSubscription{}, &baselineRssEntry{}, &baselineDelivery{})
+	}},
+	{version: 7, name: "entry_content", up: addEntryContent},
+	{version: 8, name: "feed_live_at", up: addFeedLiveAt},
+	{version: 9, name: "key_hashes", up: indexKeyHashes},
+}
+
+// schemaMigration records a migration applied to the database
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// MigrationStatus is a migration and when it was applied, a zero AppliedAt meaning it is pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Pending reports whether the migration is still to be applied.
+func (s MigrationStatus) Pending() bool {
+	return s.AppliedAt.IsZero()
+}
+
+// Status lists every migration, applied or not, without changing the database.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{Version: m.version, Name: m.name, AppliedAt: applied[m.version].AppliedAt})
+	}
+	return status, nil
+}
+
+// Migrate applies the pending migrations, each in a transaction recording it along with its changes.
+// A database holding data is backed up before the first of them, and a database migrated by a newer build is refused.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	current := 0
+	for v := range applied {
+		current = max(current, v)
+	}
+	if latest := migrations[len(migrations)-1].version; current > latest {
+		return fmt.Errorf("the database is at version %d, newer than the version %d of this build", current, latest)
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if empty, err := isEmpty(db); err != nil {
+		return err
+	} else if !empty {
+		path, err := backup(db, current)
+		if err != nil {
+			return fmt.Errorf("failed to back up the database: %w", err)
+		}
+		if path != "" {
+			slog.Info(fmt.Sprintf("backed up the database to %s", path))
+		}
+	}
+
+	// MySQL commits the changes of the schema as they are made, so a failed migration is rolled back
+	// only on the other databases
+	for _, m := range pending {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		}); err != nil {
+			return fmt.Errorf("migration %d %s failed: %w", m.version, m.name, err)
+		}
+		slog.Info(fmt.Sprintf("applied migration %d %s", m.version, m.name))
+	}
+	return nil
+}
+
+// appliedMigrations returns the migrations recorded by version, none when the table does not exist yet.
+func appliedMigrations(db *gorm.DB) (map[int]schemaMigration, error) {
+	applied := map[int]schemaMigration{}
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// isEmpty reports whether the database holds no table but the migrations, so that there is nothing to back up.
+func isEmpty(db *gorm.DB) (bool, error) {
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tables {
+		if t != "schema_migrations" && t != "sqlite_sequence" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// backup copies a SQLite database into a file next to it named after its version and the time, returning its path,
+// empty for a database in memory or on a server, which is backed up by the means of the server.
+func backup(db *gorm.DB, version int) (string, error) {
+	if db.Dialector.Name() != "sqlite" {
+		return "", nil
+	}
+	var file string
+	if err := db.Raw("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file).Error; err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", nil
+	}
+	path := fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().Format("20060102150405.000000000"))
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoEncryptionKey is returned when a value must be sealed or opened before SetEncryptionKey
+var ErrNoEncryptionKey = errors.New("ENCRYPTION_KEY is not set")
+
+var keys struct {
+	sync.RWMutex
+	aead cipher.AEAD
+	mac  []byte
+}
+
+func init() {
+	schema.RegisterSerializer("sealed", sealedSerializer{})
+}
+
+// SetEncryptionKey sets the AES key sealing the columns tagged serializer:sealed, such as the credentials of feeds.
+// The key must be 16, 24 or 32 bytes long.
+func SetEncryptionKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	// derive a distinct key for the digests, so they reveal nothing about the encryption key
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("digest"))
+
+	keys.Lock()
+	defer keys.Unlock()
+	keys.aead = aead
+	keys.mac = mac.Sum(nil)
+	return nil
+}
+
+// Digest returns a keyed digest of v telling values apart without revealing them, empty for a zero v.
+func Digest(v any) (string, error) {
+	if reflect.ValueOf(v).IsZero() {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	keys.RLock()
+	defer keys.RUnlock()
+	if keys.mac == nil {
+		return "", ErrNoEncryptionKey
+	}
+	mac := hmac.New(sha256.New, keys.mac)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// CheckSealed opens the options sealed in every feed, so that a key missing or other than the one they were sealed with
+// is told at startup rather than failing every query loading a feed.
+func CheckSealed(db *gorm.DB) error {
+	var sealed []struct {
+		ID      uint
+		Options string
+	}
+	if err := db.Table("feeds").Select("id, options").Where("options <> ''").Scan(&sealed).Error; err != nil {
+		return err
+	}
+	for _, s := range sealed {
+		if _, err := open(s.Options); err != nil {
+			return fmt.Errorf("failed to open the options of feed %d: %w", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// sealedSerializer stores a value as JSON encrypted with AES-GCM, and a zero value as an empty string,
+// so rows without a secret are readable without the key.
+type sealedSerializer struct{}
+
+func (sealedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	fieldValue := reflect.New(field.FieldType)
+	var sealed string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		sealed = v
+	case []byte:
+		sealed = string(v)
+	default:
+		return fmt.Errorf("unsupported sealed value: %T", dbValue)
+	}
+	if sealed != "" {
+		b, err := open(sealed)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", field.Name, err)
+		}
+		if err := json.Unmarshal(b, fieldValue.Interface()); err != nil {
+			return err
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (sealedSerializer) Value(_ context.Context, field *schema.Field, _ reflect.Value, fieldValue any) (any, error) {
+	if reflect.ValueOf(fieldValue).IsZero() {
+		return "", nil
+	}
+	b, err := json.Marshal(fieldValue)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal %s: %w", field.Name, err)
+	}
+	return sealed, nil
+}
+
+// seal encrypts b with a random nonce prepended.
+func seal(b []byte) (string, error) {
+	keys.RLock()
+	defer keys.RUnlock()
+	if keys.aead == nil {
+		return "", ErrNoEncryptionKey
+	}
+	nonce := make([]byte, keys.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(keys.aead.Seal(nonce, nonce, b, nil)), nil
+}
+
+func open(sealed string) ([]byte, error) {
+	keys.RLock()
+	defer keys.RUnlock()
+	if keys.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	size := keys.aead.NonceSize()
+	if len(b) < size {
+		return nil, errors.New("sealed value too short")
+	}
+	return keys.aead.Open(nil, b[:size], b[size:], nil)
+}
@@ -0,0 +1,62 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+)
+
+func TestCheckSealed(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	tests := []struct {
+		name    string
+		feeds   []model.Feed
+		key     []byte
+		wantErr bool
+	}{
+		{
+			name:  "no options",
+			feeds: []model.Feed{{ID: 1, URL: "https://example.com/1"}},
+			key:   []byte("fedcba9876543210"),
+		},
+		{
+			name:  "sealed with the key",
+			feeds: []model.Feed{{ID: 1, URL: "https://example.com/1"}, {ID: 2, URL: "https://example.com/2", Options: model.FetchOptions{Token: "secret"}}},
+			key:   key,
+		},
+		{
+			name:    "sealed with another key",
+			feeds:   []model.Feed{{ID: 1, URL: "https://example.com/1"}, {ID: 2, URL: "https://example.com/2", Options: model.FetchOptions{Token: "secret"}}},
+			key:     []byte("fedcba9876543210"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			if err := database.SetEncryptionKey(key); err != nil {
+				t.Fatal(err)
+			}
+			defer database.SetEncryptionKey(key)
+
+			// prepare
+			db.Create(&tt.feeds)
+			if err := database.SetEncryptionKey(tt.key); err != nil {
+				t.Fatal(err)
+			}
+
+			// test
+			err := database.CheckSealed(db)
+
+			// assert
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
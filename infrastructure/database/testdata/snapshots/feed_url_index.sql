@@ -0,0 +1,18 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE `feeds` (`id` integer PRIMARY KEY AUTOINCREMENT,`url` text,`title` text,`site_link` text,`icon_url` text,`e_tag` text,`last_modified` text,`created_at` datetime,`updated_at` datetime);
+INSERT INTO feeds VALUES(1,'https://a.example.com/','A',NULL,NULL,NULL,NULL,'2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO feeds VALUES(2,'https://b.example.com/','B',NULL,NULL,NULL,NULL,'2024-01-01 00:00:00','2024-01-01 00:00:00');
+CREATE TABLE `subscriptions` (`id` integer PRIMARY KEY AUTOINCREMENT,`channel_id` text,`feed_id` integer,`created_at` datetime,CONSTRAINT `fk_subscriptions_feed` FOREIGN KEY (`feed_id`) REFERENCES `feeds`(`id`));
+INSERT INTO subscriptions VALUES(1,'1',1,'2024-01-01 00:00:00');
+INSERT INTO subscriptions VALUES(2,'2',2,'2024-01-01 00:00:00');
+CREATE TABLE `rss_entries` (`id` integer PRIMARY KEY AUTOINCREMENT,`feed_id` integer,`entry_title` text,`entry_link` text,`published_at` datetime,`created_at` datetime);
+INSERT INTO rss_entries VALUES(1,1,'A1','https://a.example.com/1','2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO rss_entries VALUES(2,2,'B1','https://b.example.com/1','2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO sqlite_sequence VALUES('feeds',2);
+INSERT INTO sqlite_sequence VALUES('subscriptions',2);
+INSERT INTO sqlite_sequence VALUES('rss_entries',2);
+CREATE UNIQUE INDEX `idx_feeds_url` ON `feeds`(`url`);
+CREATE UNIQUE INDEX `idx_subscriptions_channel_feed` ON `subscriptions`(`channel_id`,`feed_id`);
+CREATE INDEX `idx_rss_entries_feed_id` ON `rss_entries`(`feed_id`);
+COMMIT;
@@ -0,0 +1,17 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE `subscriptions` (`id` integer PRIMARY KEY AUTOINCREMENT,`channel_id` text,`rss_url` text,`created_at` datetime);
+INSERT INTO subscriptions VALUES(1,'1','https://a.example.com/','2024-01-01 00:00:00');
+INSERT INTO subscriptions VALUES(2,'1','https://a.example.com/','2024-01-02 00:00:00');
+INSERT INTO subscriptions VALUES(3,'2','https://b.example.com/','2024-01-03 00:00:00');
+CREATE TABLE `rss_entries` (`id` integer PRIMARY KEY AUTOINCREMENT,`rss_url` text,`entry_title` text,`entry_link` text,`published_at` datetime,`created_at` datetime);
+INSERT INTO rss_entries VALUES(1,'https://a.example.com/','A1','https://a.example.com/1','2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO rss_entries VALUES(2,'https://a.example.com/','A1','https://a.example.com/1','2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO rss_entries VALUES(3,'https://c.example.com/','C1','https://c.example.com/1','2024-01-01 00:00:00','2024-01-01 00:00:00');
+CREATE TABLE `feed_caches` (`id` integer PRIMARY KEY AUTOINCREMENT,`rss_url` text,`e_tag` text,`last_modified` text,`updated_at` datetime);
+INSERT INTO feed_caches VALUES(1,'https://a.example.com/','"a"','','2024-01-01 00:00:00');
+INSERT INTO sqlite_sequence VALUES('subscriptions',3);
+INSERT INTO sqlite_sequence VALUES('rss_entries',3);
+INSERT INTO sqlite_sequence VALUES('feed_caches',1);
+CREATE UNIQUE INDEX `idx_feed_caches_rss_url` ON `feed_caches`(`rss_url`);
+COMMIT;
@@ -0,0 +1,23 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE `feeds` (`id` integer PRIMARY KEY AUTOINCREMENT,`url` text,`selector_item` text NOT NULL DEFAULT "",`selector_title` text NOT NULL DEFAULT "",`selector_link` text NOT NULL DEFAULT "",`selector_date` text NOT NULL DEFAULT "",`options` text,`options_digest` text NOT NULL DEFAULT "",`title` text,`site_link` text,`icon_url` text,`e_tag` text,`last_modified` text,`ttl` integer,`skip_hours` text,`skip_days` text,`consecutive_failures` integer,`last_error` text,`last_success_at` datetime,`repairs` text,`consecutive_not_found` integer,`hub_url` text,`topic_url` text,`web_sub_secret` text,`web_sub_requested_at` datetime,`web_sub_expires_at` datetime,`last_checked_at` datetime,`next_due_at` datetime,`created_at` datetime,`updated_at` datetime);
+INSERT INTO feeds VALUES(1,'https://a.example.com/','','','','',NULL,'','A',NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,NULL,'2024-01-01 00:00:00','2024-01-01 00:00:00');
+CREATE TABLE `subscriptions` (`id` integer PRIMARY KEY AUTOINCREMENT,`channel_id` text,`feed_id` integer,`interval` integer,`status` text DEFAULT "active",`on_update` text NOT NULL DEFAULT "edit",`created_at` datetime,CONSTRAINT `fk_subscriptions_feed` FOREIGN KEY (`feed_id`) REFERENCES `feeds`(`id`));
+INSERT INTO subscriptions VALUES(1,'1',1,0,'active','edit','2024-01-01 00:00:00');
+CREATE TABLE `rss_entries` (`id` integer PRIMARY KEY AUTOINCREMENT,`feed_id` integer,`identity` text NOT NULL DEFAULT "",`entry_title` text,`entry_link` text,`published_at` datetime,`content_hash` text NOT NULL DEFAULT "",`seen_at` datetime,`created_at` datetime);
+INSERT INTO rss_entries VALUES(1,1,'guid:1','A1','https://a.example.com/1','2024-01-01 00:00:00','h','2024-01-01 00:00:00','2024-01-01 00:00:00');
+CREATE TABLE `deliveries` (`id` integer PRIMARY KEY AUTOINCREMENT,`subscription_id` integer,`entry_id` integer,`status` text NOT NULL DEFAULT "sent",`action` text NOT NULL DEFAULT "post",`nonce` text,`attempts` integer,`last_error` text,`message_id` text,`next_attempt_at` datetime,`created_at` datetime,`updated_at` datetime);
+INSERT INTO deliveries VALUES(1,1,1,'pending','post','n',0,'','','2024-01-01 00:00:00','2024-01-01 00:00:00','2024-01-01 00:00:00');
+INSERT INTO sqlite_sequence VALUES('feeds',1);
+INSERT INTO sqlite_sequence VALUES('subscriptions',1);
+INSERT INTO sqlite_sequence VALUES('rss_entries',1);
+INSERT INTO sqlite_sequence VALUES('deliveries',1);
+CREATE INDEX `idx_feeds_next_due_at` ON `feeds`(`next_due_at`);
+CREATE UNIQUE INDEX `idx_feeds_source` ON `feeds`(`url`,`selector_item`,`selector_title`,`selector_link`,`selector_date`,`options_digest`);
+CREATE UNIQUE INDEX `idx_subscriptions_channel_feed` ON `subscriptions`(`channel_id`,`feed_id`);
+CREATE INDEX `idx_rss_entries_seen` ON `rss_entries`(`feed_id`,`seen_at`);
+CREATE UNIQUE INDEX `idx_rss_entries_identity` ON `rss_entries`(`feed_id`,`identity`);
+CREATE INDEX `idx_rss_entries_feed_id` ON `rss_entries`(`feed_id`);
+CREATE INDEX `idx_deliveries_due` ON `deliveries`(`status`,`next_attempt_at`);
+CREATE UNIQUE INDEX `idx_deliveries_subscription_entry` ON `deliveries`(`subscription_id`,`entry_id`);
+COMMIT;
@@ -0,0 +1,59 @@
+package fetch
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// xmlEncoding matches the encoding of an XML declaration
+var xmlEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// toUTF8 transcodes a feed document to UTF-8 and declares it as such, so that every parser reads it the same way.
+// The encoding is told by a byte order mark, then by the XML declaration, then by the charset of contentType.
+// The declaration is preferred over the header, as servers often send a default charset for every file
+// while the declaration is written along with the document. A document telling nothing is taken as UTF-8.
+func toUTF8(body []byte, contentType string) ([]byte, error) {
+	var enc encoding.Encoding
+	switch {
+	case bytes.HasPrefix(body, []byte{0xEF, 0xBB, 0xBF}):
+		body = body[3:]
+	case bytes.HasPrefix(body, []byte{0xFF, 0xFE}):
+		enc = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(body, []byte{0xFE, 0xFF}):
+		enc = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	default:
+		label := ""
+		if m := xmlEncoding.FindSubmatch(body); m != nil {
+			label = string(m[1])
+		} else if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			label = params["charset"]
+		}
+		if label != "" {
+			e, name := charset.Lookup(label)
+			if e == nil {
+				return nil, fmt.Errorf("unsupported charset: %s", label)
+			}
+			if name != "utf-8" {
+				enc = e
+			}
+		}
+	}
+	if enc != nil {
+		decoded, err := enc.NewDecoder().Bytes(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the document: %w", err)
+		}
+		body = decoded
+	}
+	if m := xmlEncoding.FindSubmatchIndex(body); m != nil && !strings.EqualFold(string(body[m[2]:m[3]]), "utf-8") {
+		body = append(append(bytes.Clone(body[:m[2]]), "UTF-8"...), body[m[3]:]...)
+	}
+	return body, nil
+}
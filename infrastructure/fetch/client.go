@@ -0,0 +1,268 @@
+package fetch
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+var (
+	// ErrBlockedTarget is returned for a request to a scheme, a port or an address the client refuses
+	ErrBlockedTarget = errors.New("blocked target")
+	// ErrTooLarge is returned while reading a response body over the size limits
+	ErrTooLarge = errors.New("response too large")
+)
+
+// blockedNetworks are not publicly routable, on top of the loopback, private, link-local and multicast addresses
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Guard configures what a client made by NewClient may reach and read.
+type Guard struct {
+	// Allow lists the networks reachable even though they are not public, such as a feed server on the LAN
+	Allow []netip.Prefix
+	// Ports lists the ports requests may target, 80 and 443 when empty
+	Ports []int
+	// MaxBodySize caps the bytes read from the network, MaxDecompressedSize the bytes of a body after decompression.
+	// Zero means no limit.
+	MaxBodySize         int
+	MaxDecompressedSize int
+	// MaxRedirects caps the redirects followed, 10 when zero
+	MaxRedirects int
+}
+
+// NewClient returns an HTTP client for URLs given by users. It only requests http and https URLs
+// on the allowed ports, and refuses to connect to non-public addresses after DNS resolution,
+// so a hostname resolving to an internal address is blocked too.
+func NewClient(g Guard) *http.Client {
+	if len(g.Ports) == 0 {
+		g.Ports = []int{80, 443}
+	}
+	if g.MaxRedirects == 0 {
+		g.MaxRedirects = 10
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return g.checkAddress(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the target, bypassing the address check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	// bodies are decompressed by guardTransport, so the compressed size can be capped too
+	transport.DisableCompression = true
+	return &http.Client{
+		Transport: guardTransport{base: transport, guard: g},
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if len(via) > g.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", g.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// Proxied returns a copy of client sending its requests through proxy, or client itself when proxy is empty.
+func Proxied(client *http.Client, proxy string) (*http.Client, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if proxy == "" {
+		return client, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	transport, err := withProxy(client.Transport, u)
+	if err != nil {
+		return nil, err
+	}
+	proxied := *client
+	proxied.Transport = transport
+	return &proxied, nil
+}
+
+// SetOptions sets the user agent, the headers and the credentials of options on req.
+// The user agent of req is kept when options has none.
+func SetOptions(req *http.Request, options model.FetchOptions) {
+	if options.UserAgent != "" {
+		req.Header.Set("User-Agent", options.UserAgent)
+	}
+	for name, value := range options.Headers {
+		req.Header.Set(name, value)
+	}
+	switch {
+	case options.Token != "":
+		req.Header.Set("Authorization", "Bearer "+options.Token)
+	case options.Username != "" || options.Password != "":
+		req.SetBasicAuth(options.Username, options.Password)
+	}
+}
+
+// withProxy returns a copy of rt sending its requests through proxy, an http, https or socks5 URL.
+// Under a guardTransport the proxy is dialed instead of the target, so the address check applies to the proxy,
+// while the scheme and the port of the target are still checked.
+func withProxy(rt http.RoundTripper, proxy *url.URL) (http.RoundTripper, error) {
+	switch t := rt.(type) {
+	case nil:
+		return withProxy(http.DefaultTransport, proxy)
+	case guardTransport:
+		base, err := withProxy(t.base, proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.base = base
+		return t, nil
+	case *http.Transport:
+		t = t.Clone()
+		t.Proxy = http.ProxyURL(proxy)
+		return t, nil
+	}
+	return nil, fmt.Errorf("cannot use a proxy with a %T transport", rt)
+}
+
+// checkAddress refuses to dial an address that is not public, unless it is allowed.
+func (g Guard) checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	for _, p := range g.Allow {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrBlockedTarget, addr)
+	}
+	for _, p := range blockedNetworks {
+		if p.Contains(addr) {
+			return fmt.Errorf("%w: %s", ErrBlockedTarget, addr)
+		}
+	}
+	return nil
+}
+
+// guardTransport checks the scheme and the port of every request, redirects included,
+// and limits the bodies of the responses.
+type guardTransport struct {
+	base  http.RoundTripper
+	guard Guard
+}
+
+func (t guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %s", ErrBlockedTarget, req.URL.Scheme)
+	}
+	port := req.URL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[req.URL.Scheme]
+	}
+	if p, err := strconv.Atoi(port); err != nil || !slices.Contains(t.guard.Ports, p) {
+		return nil, fmt.Errorf("%w: port %s", ErrBlockedTarget, port)
+	}
+
+	requested := req.Header.Get("Accept-Encoding") == ""
+	if requested {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.guard.MaxBodySize > 0 && resp.ContentLength > int64(t.guard.MaxBodySize) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, reader: resp.Body, limit: t.guard.MaxBodySize}
+	// only decompress what was asked here, a caller asking for an encoding gets it as is
+	if requested && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		resp.Body = &gzipBody{ReadCloser: resp.Body, limit: t.guard.MaxDecompressedSize}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	} else if t.guard.MaxDecompressedSize > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, reader: resp.Body, limit: t.guard.MaxDecompressedSize}
+	}
+	return resp, nil
+}
+
+// limitedBody fails a read past its limit rather than truncating the body silently.
+// A zero limit means no limit.
+type limitedBody struct {
+	io.ReadCloser
+	reader io.Reader
+	limit  int
+	read   int
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit == 0 {
+		return b.reader.Read(p)
+	}
+	if b.read > b.limit {
+		return 0, ErrTooLarge
+	}
+	// read one byte over the limit to tell a body of exactly the limit from a larger one
+	if len(p) > b.limit-b.read+1 {
+		p = p[:b.limit-b.read+1]
+	}
+	n, err := b.reader.Read(p)
+	b.read += n
+	if b.read > b.limit {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// gzipBody decompresses a gzip body lazily, up to limit bytes.
+type gzipBody struct {
+	io.ReadCloser
+	limit int
+	body  *limitedBody
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.body == nil {
+		zr, err := gzip.NewReader(b.ReadCloser)
+		if err != nil {
+			return 0, err
+		}
+		b.body = &limitedBody{ReadCloser: b.ReadCloser, reader: zr, limit: b.limit}
+	}
+	return b.body.Read(p)
+}
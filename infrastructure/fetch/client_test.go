@@ -0,0 +1,162 @@
+package fetch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+)
+
+func TestGuardCheckAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []netip.Prefix
+		address string
+		wantErr bool
+	}{
+		{name: "public", address: "93.184.216.34:443"},
+		{name: "public ipv6", address: "[2606:2800:220:1::]:443"},
+		{name: "loopback", address: "127.0.0.1:80", wantErr: true},
+		{name: "metadata", address: "169.254.169.254:80", wantErr: true},
+		{name: "private", address: "10.1.2.3:80", wantErr: true},
+		{name: "shared", address: "100.64.0.1:80", wantErr: true},
+		{name: "unspecified", address: "0.0.0.0:80", wantErr: true},
+		{name: "mapped loopback", address: "[::ffff:127.0.0.1]:80", wantErr: true},
+		{name: "ipv6 loopback", address: "[::1]:80", wantErr: true},
+		{name: "ipv6 unique local", address: "[fd00::1]:80", wantErr: true},
+		{name: "ipv6 link local", address: "[fe80::1]:80", wantErr: true},
+		{name: "allowed private", allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, address: "10.1.2.3:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			err := fetch.Guard{Allow: tt.allow}.CheckAddress(tt.address)
+
+			// assert
+			if tt.wantErr != errors.Is(err, fetch.ErrBlockedTarget) {
+				t.Errorf("want blocked: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	large := strings.Repeat("a", 1000)
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write([]byte(large))
+	_ = zw.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(large))
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		for range 10 {
+			_, _ = w.Write([]byte(large[:100]))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("want: gzip, got: %s", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(zipped.Bytes())
+	})
+	mux.Handle("/redirect/1", http.RedirectHandler("/small", http.StatusFound))
+	mux.Handle("/redirect/2", http.RedirectHandler("/redirect/1", http.StatusFound))
+	mux.Handle("/redirect/3", http.RedirectHandler("/redirect/2", http.StatusFound))
+	mux.Handle("/redirect/scheme", http.RedirectHandler("file:///etc/passwd", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	tests := []struct {
+		name    string
+		guard   fetch.Guard
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "allowed", guard: fetch.Guard{Allow: loopback, Ports: []int{port}}, path: "/small", want: "ok"},
+		{name: "loopback blocked", guard: fetch.Guard{Ports: []int{port}}, path: "/small", wantErr: fetch.ErrBlockedTarget},
+		{name: "port blocked", guard: fetch.Guard{Allow: loopback}, path: "/small", wantErr: fetch.ErrBlockedTarget},
+		{name: "scheme blocked on redirect", guard: fetch.Guard{Allow: loopback, Ports: []int{port}}, path: "/redirect/scheme", wantErr: fetch.ErrBlockedTarget},
+		{name: "body at the limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxBodySize: 1000}, path: "/large", want: large},
+		{name: "body over the limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxBodySize: 999}, path: "/large", wantErr: fetch.ErrTooLarge},
+		{name: "chunked body over the limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxBodySize: 999}, path: "/chunked", wantErr: fetch.ErrTooLarge},
+		{name: "gzip decompressed", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxBodySize: 100, MaxDecompressedSize: 1000}, path: "/gzip", want: large},
+		{name: "gzip over the decompressed limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxDecompressedSize: 999}, path: "/gzip", wantErr: fetch.ErrTooLarge},
+		{name: "redirects within the limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxRedirects: 2}, path: "/redirect/2", want: "ok"},
+		{name: "redirects over the limit", guard: fetch.Guard{Allow: loopback, Ports: []int{port}, MaxRedirects: 2}, path: "/redirect/3", wantErr: errors.New("stopped after 2 redirects")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			client := fetch.NewClient(tt.guard)
+
+			// test
+			got, err := get(client, srv.URL+tt.path)
+
+			// assert
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("want: nil, got: %v", err)
+			case tt.wantErr != nil && (err == nil || !errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error())):
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want: %d bytes, got: %d bytes", len(tt.want), len(got))
+			}
+		})
+	}
+}
+
+func TestRssFetchBlocked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	// test
+	_, err := fetch.NewRss(fetch.NewClient(fetch.Guard{}), model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL})
+
+	// assert
+	if !errors.Is(err, fetch.ErrBlockedTarget) {
+		t.Errorf("want: %v, got: %v", fetch.ErrBlockedTarget, err)
+	}
+}
+
+// get returns the body at rawURL, empty on error.
+func get(client *http.Client, rawURL string) (string, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,142 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/mmcdole/gofeed"
+)
+
+// feedTypes are the link types advertised by <link rel="alternate">
+var feedTypes = map[string]struct{}{
+	"application/rss+xml":   {},
+	"application/atom+xml":  {},
+	"application/feed+json": {},
+}
+
+// commonPaths are probed when a page does not advertise any feed
+var commonPaths = []string{"/feed", "/index.xml", "/rss", "/feed.xml", "/atom.xml", "/rss.xml"}
+
+type Discoverer struct {
+	*gofeed.Parser
+	defaults model.FetchOptions
+	timeout  time.Duration
+	// client is Client sending its requests through the proxy of defaults
+	client func() (*http.Client, error)
+}
+
+// NewDiscoverer returns a discoverer sending its requests with client, cancelled after timeout.
+// The requests carry defaults, the options of the feeds not subscribed to yet.
+func NewDiscoverer(client *http.Client, defaults model.FetchOptions, timeout time.Duration) Discoverer {
+	parser := gofeed.NewParser()
+	parser.Client = client
+	return Discoverer{Parser: parser, defaults: defaults, timeout: timeout, client: sync.OnceValues(func() (*http.Client, error) {
+		return Proxied(client, defaults.Proxy)
+	})}
+}
+
+func (d Discoverer) Discover(ctx context.Context, pageURL string) ([]repository.DiscoveredFeed, error) {
+	body, base, err := d.get(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	// a feed is recognized as the poll reads it, repaired when malformed
+	if feed, _, _, err := parseRepaired(d.Parser, body); err == nil {
+		return []repository.DiscoveredFeed{{URL: pageURL, Title: feed.Title}}, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if feeds := alternateLinks(doc, base); len(feeds) > 0 {
+		return feeds, nil
+	}
+
+	// the first common path that serves a feed wins, since sites often serve the same feed at several paths
+	for _, p := range commonPaths {
+		candidate := base.ResolveReference(&url.URL{Path: p}).String()
+		body, _, err := d.get(ctx, candidate)
+		if err != nil {
+			continue
+		}
+		if feed, _, _, err := parseRepaired(d.Parser, body); err == nil {
+			return []repository.DiscoveredFeed{{URL: candidate, Title: feed.Title}}, nil
+		}
+	}
+	return nil, repository.ErrFeedNotFound
+}
+
+// get returns the body transcoded to UTF-8 and the final URL after redirects, which relative links resolve against.
+func (d Discoverer) get(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", d.UserAgent)
+	SetOptions(req, d.defaults)
+
+	client, err := d.client()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err = toUTF8(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Request.URL, nil
+}
+
+func alternateLinks(doc *goquery.Document, base *url.URL) []repository.DiscoveredFeed {
+	feeds := []repository.DiscoveredFeed{}
+	seen := map[string]struct{}{}
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		rel := strings.Fields(strings.ToLower(s.AttrOr("rel", "")))
+		if !slices.Contains(rel, "alternate") {
+			return
+		}
+		typ := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+		if _, ok := feedTypes[typ]; !ok {
+			return
+		}
+		href, err := base.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil {
+			return
+		}
+		u := href.String()
+		if _, ok := seen[u]; ok {
+			return
+		}
+		seen[u] = struct{}{}
+		feeds = append(feeds, repository.DiscoveredFeed{URL: u, Title: strings.TrimSpace(s.AttrOr("title", ""))})
+	})
+	return feeds
+}
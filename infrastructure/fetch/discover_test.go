@@ -0,0 +1,138 @@
+package fetch_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiscover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/malformed.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<?xml version=\"1.0\"?><rss version=\"2.0\"><channel><title>Tom & Jerry\x01</title></channel></rss>"))
+	})
+	// in Shift_JIS, told by the header only
+	mux.HandleFunc("/sjis.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=Shift_JIS")
+		_, _ = w.Write([]byte("<?xml version=\"1.0\"?><rss version=\"2.0\"><channel><title>\x83\x65\x83\x58\x83\x67</title></channel></rss>"))
+	})
+	mux.HandleFunc("/sjis/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+		_, _ = w.Write([]byte("<html><head><link rel=\"alternate\" type=\"application/rss+xml\" title=\"\x83\x65\x83\x58\x83\x67\" href=\"/sjis.xml\"></head></html>"))
+	})
+	mux.HandleFunc("/links/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head>
+<link rel="alternate" type="application/rss+xml" title="RSS" href="/feed.xml">
+<link rel="alternate" type="application/atom+xml" title="Atom" href="atom.xml">
+<link rel="alternate" type="application/rss+xml" title="Duplicate" href="/feed.xml">
+<link rel="stylesheet" type="text/css" href="/style.css">
+</head><body></body></html>`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<html><head><title>no feed</title></head></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html></html>`))
+	}))
+	defer empty.Close()
+
+	tests := []struct {
+		name    string
+		args    string
+		want    []repository.DiscoveredFeed
+		wantErr error
+	}{
+		{
+			name: "feed url",
+			args: srv.URL + "/feed.xml",
+			want: []repository.DiscoveredFeed{{URL: srv.URL + "/feed.xml", Title: "example"}},
+		},
+		{
+			name: "malformed feed url",
+			args: srv.URL + "/malformed.xml",
+			want: []repository.DiscoveredFeed{{URL: srv.URL + "/malformed.xml", Title: "Tom & Jerry"}},
+		},
+		{
+			name: "charset of the header",
+			args: srv.URL + "/sjis.xml",
+			want: []repository.DiscoveredFeed{{URL: srv.URL + "/sjis.xml", Title: "テスト"}},
+		},
+		{
+			name: "alternate links in the charset of the header",
+			args: srv.URL + "/sjis/",
+			want: []repository.DiscoveredFeed{{URL: srv.URL + "/sjis.xml", Title: "テスト"}},
+		},
+		{
+			name: "alternate links",
+			args: srv.URL + "/links/",
+			want: []repository.DiscoveredFeed{
+				{URL: srv.URL + "/feed.xml", Title: "RSS"},
+				{URL: srv.URL + "/links/atom.xml", Title: "Atom"},
+			},
+		},
+		{
+			name: "common path",
+			args: srv.URL + "/",
+			want: []repository.DiscoveredFeed{{URL: srv.URL + "/feed.xml", Title: "example"}},
+		},
+		{
+			name:    "not found",
+			args:    empty.URL,
+			wantErr: repository.ErrFeedNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetch.NewDiscoverer(http.DefaultClient, model.FetchOptions{}, 0).Discover(context.Background(), tt.args)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func TestDiscoverDefaults(t *testing.T) {
+	var got *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer proxy.Close()
+
+	// test
+	defaults := model.FetchOptions{Headers: map[string]string{"X-Api-Key": "key"}, Proxy: proxy.URL, UserAgent: "bot/1.0"}
+	feeds, err := fetch.NewDiscoverer(http.DefaultClient, defaults, 0).Discover(context.Background(), "http://feed.invalid/feed")
+
+	// assert
+	if err != nil {
+		t.Fatalf("want: nil, got: %v", err)
+	}
+	if len(feeds) != 1 || feeds[0].Title != "example" {
+		t.Errorf("want: the feed, got: %v", feeds)
+	}
+	// a proxy receives the absolute URL of the target
+	if got.URL.String() != "http://feed.invalid/feed" || got.UserAgent() != "bot/1.0" || got.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("want: the defaults sent through the proxy, got: %s %v", got.URL, got.Header)
+	}
+}
@@ -0,0 +1,5 @@
+package fetch
+
+func (g Guard) CheckAddress(address string) error {
+	return g.checkAddress(address)
+}
@@ -0,0 +1,77 @@
+package fetch
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed/rss"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// rssHints reads <ttl>, <skipHours> and <skipDays>, which the universal parser drops.
+// Invalid values are ignored.
+func rssHints(body []byte) (ttl time.Duration, skipHours []int, skipDays []time.Weekday) {
+	feed, err := (&rss.Parser{}).Parse(bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil
+	}
+	if minutes, err := strconv.Atoi(strings.TrimSpace(feed.TTL)); err == nil && minutes > 0 {
+		ttl = time.Duration(minutes) * time.Minute
+	}
+	for _, h := range feed.SkipHours {
+		// hours are in GMT, 24 being an alias of 0 in some feeds
+		if hour, err := strconv.Atoi(strings.TrimSpace(h)); err == nil && hour >= 0 && hour <= 24 {
+			skipHours = append(skipHours, hour%24)
+		}
+	}
+	for _, d := range feed.SkipDays {
+		if day, ok := weekdays[strings.ToLower(strings.TrimSpace(d))]; ok {
+			skipDays = append(skipDays, day)
+		}
+	}
+	return ttl, skipHours, skipDays
+}
+
+// maxAge returns the max-age of the Cache-Control header, or zero when the response may not be cached.
+func maxAge(h http.Header) time.Duration {
+	age := time.Duration(0)
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds > 0 {
+				age = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return age
+}
+
+// retryAfter returns the delay of the Retry-After header, given either in seconds or as an HTTP date.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
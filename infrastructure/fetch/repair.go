@@ -0,0 +1,225 @@
+package fetch
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// The repairs reported in FetchResult.Repairs
+const (
+	RepairInvalidCharacters = "invalid characters"
+	RepairEscapes           = "unescaped characters"
+	RepairTruncated         = "truncated document"
+)
+
+var (
+	// entityRef matches a character or entity reference at the start of its input
+	entityRef  = regexp.MustCompile(`^&(#[0-9]{1,8}|#[xX][0-9a-fA-F]{1,8}|[A-Za-z][A-Za-z0-9]{0,31});`)
+	cdataStart = []byte("<![CDATA[")
+	cdataEnd   = []byte("]]>")
+	comment    = []byte("<!--")
+	commentEnd = []byte("-->")
+)
+
+// parseRepaired parses body with parser, then once repaired when it is malformed.
+// It returns the feed along with the document parsed and the repairs made,
+// and the error of the original document when the repaired one fails too.
+func parseRepaired(parser *gofeed.Parser, body []byte) (*gofeed.Feed, []byte, []string, error) {
+	parsed, err := parser.Parse(bytes.NewReader(body))
+	if err == nil {
+		return parsed, body, nil, nil
+	}
+	fixed, fixes := repair(body)
+	if len(fixes) == 0 {
+		return nil, nil, nil, err
+	}
+	reparsed, retryErr := parser.Parse(bytes.NewReader(fixed))
+	if retryErr != nil {
+		return nil, nil, nil, err
+	}
+	return reparsed, fixed, fixes, nil
+}
+
+// repair fixes the usual breakage of feed documents, returning the fixed document and what was repaired,
+// no repair meaning the document could not be improved. It removes the characters XML forbids,
+// escapes the ampersands not starting a reference and the less-than signs not starting a tag,
+// turns HTML entities into character references, then closes the elements left open by a truncated document,
+// dropping its incomplete tail.
+func repair(body []byte) ([]byte, []string) {
+	var repairs []string
+	if fixed, ok := repairCharacters(body); ok {
+		body = fixed
+		repairs = append(repairs, RepairInvalidCharacters)
+	}
+	if fixed, ok := repairEscapes(body); ok {
+		body = fixed
+		repairs = append(repairs, RepairEscapes)
+	}
+	if fixed, ok := closeTruncated(body); ok {
+		body = fixed
+		repairs = append(repairs, RepairTruncated)
+	}
+	return body, repairs
+}
+
+// isXMLChar reports whether r may appear in an XML 1.0 document.
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF
+}
+
+// repairCharacters drops the control characters and replaces the invalid UTF-8 sequences.
+func repairCharacters(body []byte) ([]byte, bool) {
+	valid := utf8.Valid(body)
+	if valid && bytes.IndexFunc(body, func(r rune) bool { return !isXMLChar(r) }) < 0 {
+		return body, false
+	}
+	out := make([]byte, 0, len(body))
+	for len(body) > 0 {
+		r, size := utf8.DecodeRune(body)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			out = utf8.AppendRune(out, utf8.RuneError)
+		case isXMLChar(r):
+			out = append(out, body[:size]...)
+		}
+		body = body[size:]
+	}
+	return out, true
+}
+
+// repairEscapes escapes the stray ampersands and less-than signs and converts the references XML does not define,
+// leaving the CDATA sections and the comments as they are.
+func repairEscapes(body []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	changed := false
+	for i := 0; i < len(body); {
+		rest := body[i:]
+		switch {
+		case bytes.HasPrefix(rest, cdataStart):
+			n := skip(rest, cdataEnd)
+			out.Write(rest[:n])
+			i += n
+		case bytes.HasPrefix(rest, comment):
+			n := skip(rest, commentEnd)
+			out.Write(rest[:n])
+			i += n
+		case rest[0] == '<':
+			if len(rest) > 1 && startsTag(rest[1]) {
+				out.WriteByte('<')
+			} else {
+				out.WriteString("&lt;")
+				changed = true
+			}
+			i++
+		case rest[0] == '&':
+			ref := entityRef.Find(rest)
+			if ref == nil {
+				out.WriteString("&amp;")
+				changed = true
+				i++
+				continue
+			}
+			fixed, ok := reference(ref)
+			out.WriteString(fixed)
+			changed = changed || !ok
+			i += len(ref)
+		default:
+			out.WriteByte(rest[0])
+			i++
+		}
+	}
+	if !changed {
+		return body, false
+	}
+	return out.Bytes(), true
+}
+
+// startsTag reports whether c may follow the < of a tag, a comment or a processing instruction.
+func startsTag(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || c >= 0x80 ||
+		c == '/' || c == '!' || c == '?'
+}
+
+// skip returns the length of b up to and including end, all of b when end is missing.
+func skip(b, end []byte) int {
+	if n := bytes.Index(b, end); n >= 0 {
+		return n + len(end)
+	}
+	return len(b)
+}
+
+// reference returns ref when XML defines it, and false with its fix otherwise.
+func reference(ref []byte) (string, bool) {
+	name := string(ref[1 : len(ref)-1])
+	if name[0] == '#' {
+		code, err := strconv.ParseInt(name[1:], 10, 32)
+		if name[1] == 'x' || name[1] == 'X' {
+			code, err = strconv.ParseInt(name[2:], 16, 32)
+		}
+		if err != nil || !isXMLChar(rune(code)) {
+			// a reference to a forbidden character is dropped like the character itself
+			return "", false
+		}
+		return string(ref), true
+	}
+	switch name {
+	case "amp", "lt", "gt", "quot", "apos":
+		return string(ref), true
+	}
+	s := html.UnescapeString(string(ref))
+	if s == string(ref) {
+		// not an entity at all, such as a query parameter of an unescaped URL
+		return "&amp;" + string(ref[1:]), false
+	}
+	var refs string
+	for _, r := range s {
+		refs += fmt.Sprintf("&#%d;", r)
+	}
+	return refs, false
+}
+
+// closeTruncated cuts a document ending in the middle of a token after the last complete one,
+// and closes the elements still open.
+func closeTruncated(body []byte) ([]byte, bool) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	var open []xml.Name
+	var offset int64
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			open = append(open, t.Name)
+		case xml.EndElement:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == t.Name {
+					open = open[:i]
+					break
+				}
+			}
+		}
+		offset = d.InputOffset()
+	}
+	if len(open) == 0 {
+		return body, false
+	}
+	out := bytes.Clone(body[:offset])
+	for i := len(open) - 1; i >= 0; i-- {
+		name := open[i].Local
+		if open[i].Space != "" {
+			name = open[i].Space + ":" + name
+		}
+		out = append(out, "</"+name+">"...)
+	}
+	return out, true
+}
@@ -0,0 +1,92 @@
+package fetch_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRssParseFeedRepair(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantTitles  []string
+		wantRepairs []string
+		wantErr     bool
+	}{
+		{
+			name:       "well-formed",
+			body:       `<rss version="2.0"><channel><title>feed</title><item><title>Tom &amp; Jerry</title><link>https://example.com/1</link></item></channel></rss>`,
+			wantTitles: []string{"Tom & Jerry"},
+		},
+		{
+			name:        "unescaped characters",
+			body:        `<rss version="2.0"><channel><title>feed</title><item><title>1 < 2 &amp; AT&T &copy;</title><link>https://example.com/?a=1&b=2</link></item></channel></rss>`,
+			wantTitles:  []string{"1 < 2 & AT&T ©"},
+			wantRepairs: []string{fetch.RepairEscapes},
+		},
+		{
+			name:        "reference to a forbidden character",
+			body:        `<rss version="2.0"><channel><title>feed</title><item><title>a&#0;b</title><link>https://example.com/1</link></item></channel></rss>`,
+			wantTitles:  []string{"ab"},
+			wantRepairs: []string{fetch.RepairEscapes},
+		},
+		{
+			name:        "CDATA and comments left as is",
+			body:        `<rss version="2.0"><channel><title>feed</title><!-- a < b --><item><title><![CDATA[1 < 2]]> <- ok</title><link>https://example.com/1</link></item></channel></rss>`,
+			wantTitles:  []string{"1 < 2 <- ok"},
+			wantRepairs: []string{fetch.RepairEscapes},
+		},
+		{
+			name:        "control characters",
+			body:        "<rss version=\"2.0\"><channel><title>feed</title><item><title>bad\x0b title\x00</title><link>https://example.com/1</link></item></channel></rss>",
+			wantTitles:  []string{"bad title"},
+			wantRepairs: []string{fetch.RepairInvalidCharacters},
+		},
+		{
+			name:        "truncated",
+			body:        `<rss version="2.0"><channel><title>feed</title><item><title>first</title><link>https://example.com/1</link></item><item><title>sec`,
+			wantTitles:  []string{"first", "sec"},
+			wantRepairs: []string{fetch.RepairTruncated},
+		},
+		{
+			name:        "truncated in a tag",
+			body:        `<rss version="2.0"><channel><title>feed</title><item><title>first</title><link>https://example.com/1</link></item><item><tit`,
+			wantTitles:  []string{"first", ""},
+			wantRepairs: []string{fetch.RepairTruncated},
+		},
+		{
+			name:    "not a feed",
+			body:    `<!DOCTYPE html><html><body>Tom & Jerry</body></html>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).ParseFeed([]byte(tt.body))
+
+			// assert
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error: %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			titles := []string{}
+			for _, item := range got.Items {
+				titles = append(titles, item.Title)
+			}
+			if diff := cmp.Diff(titles, tt.wantTitles); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+			if diff := cmp.Diff(got.Repairs, tt.wantRepairs); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+		})
+	}
+}
@@ -1,21 +1,190 @@
 package fetch
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
 	"github.com/mmcdole/gofeed"
 )
 
 type Rss struct {
 	*gofeed.Parser
+	defaults model.FetchOptions
+	timeout  time.Duration
+	// proxies caches the clients derived from Client for every proxy in use
+	proxies *sync.Map
+}
+
+// NewRss returns a fetcher sending its requests with client, cancelled after timeout.
+// The options of a feed are completed with defaults. A zero timeout only relies on the caller's context.
+func NewRss(client *http.Client, defaults model.FetchOptions, timeout time.Duration) Rss {
+	parser := gofeed.NewParser()
+	parser.Client = client
+	return Rss{Parser: parser, defaults: defaults, timeout: timeout, proxies: &sync.Map{}}
 }
 
-func NewRss() Rss {
-	return Rss{gofeed.NewParser()}
+// Fetch sends a conditional GET using the validators stored on feed.
+// A 304 response is reported as NotModified without parsing the body.
+// The polling hints of the response are returned even along with an HTTP error.
+// When every redirect followed was permanent, the final URL is returned as MovedTo.
+func (r Rss) Fetch(ctx context.Context, feed model.Feed) (repository.FetchResult, error) {
+	hints, body, resp, err := r.get(ctx, feed)
+	if err != nil || hints.NotModified {
+		return hints, err
+	}
+	body, err = toUTF8(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return hints, err
+	}
+	result, err := r.ParseFeed(body)
+	if err != nil {
+		return hints, err
+	}
+	result.ETag = hints.ETag
+	result.LastModified = hints.LastModified
+	result.MovedTo = hints.MovedTo
+	result.MaxAge = hints.MaxAge
+	result.RetryAfter = hints.RetryAfter
+	// the Link header takes precedence over the links of the document
+	if hub, self := linkHeader(resp.Header); hub != "" {
+		result.HubURL = hub
+		result.SelfURL = self
+	}
+	result.HubURL = resolve(resp.Request.URL, result.HubURL)
+	result.SelfURL = resolve(resp.Request.URL, result.SelfURL)
+	return result, nil
 }
 
-func (r Rss) Fetch(rssURL string) ([]*gofeed.Item, error) {
-	feed, err := r.ParseURL(rssURL)
+// get sends the conditional GET of feed and reads the body of a successful response.
+// The result only holds what the response headers tell: the validators, the redirect and the polling hints.
+// The body is nil when the feed was not modified, and the returned response is already closed.
+func (r Rss) get(ctx context.Context, feed model.Feed) (repository.FetchResult, []byte, *http.Response, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return repository.FetchResult{}, nil, nil, err
+	}
+	options := feed.Options.Merge(r.defaults)
+	req.Header.Set("User-Agent", r.UserAgent)
+	SetOptions(req, options)
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	client, err := r.client(options.Proxy)
+	if err != nil {
+		return repository.FetchResult{}, nil, nil, err
+	}
+	movedTo, permanent := "", true
+	redirects := *client
+	check := client.CheckRedirect
+	redirects.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if check != nil {
+			if err := check(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		// the credentials and the custom headers are meant for the host of the feed only
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del("Authorization")
+			for name := range options.Headers {
+				req.Header.Del(name)
+			}
+		}
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			if permanent {
+				movedTo = req.URL.String()
+			}
+		default:
+			permanent = false
+		}
+		return nil
+	}
+	resp, err := redirects.Do(req)
+	if err != nil {
+		return repository.FetchResult{}, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	hints := repository.FetchResult{MaxAge: maxAge(resp.Header), RetryAfter: retryAfter(resp.Header, time.Now())}
+	if resp.StatusCode == http.StatusNotModified {
+		hints.MovedTo = movedTo
+		hints.ETag = feed.ETag
+		hints.LastModified = feed.LastModified
+		hints.NotModified = true
+		return hints, nil, resp, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return hints, nil, resp, gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return hints, nil, resp, err
+	}
+	hints.ETag = resp.Header.Get("ETag")
+	hints.LastModified = resp.Header.Get("Last-Modified")
+	hints.MovedTo = movedTo
+	return hints, body, resp, nil
+}
+
+// client returns Client sending its requests through proxy, or Client itself when proxy is empty.
+func (r Rss) client(proxy string) (*http.Client, error) {
+	if proxy == "" {
+		return Proxied(r.Client, "")
+	}
+	if c, ok := r.proxies.Load(proxy); ok {
+		return c.(*http.Client), nil
+	}
+	proxied, err := Proxied(r.Client, proxy)
 	if err != nil {
 		return nil, err
 	}
-	return feed.Items, nil
+	c, _ := r.proxies.LoadOrStore(proxy, proxied)
+	return c.(*http.Client), nil
+}
+
+// ParseFeed parses a whole feed document, such as the content pushed by a WebSub hub.
+// A document in another encoding than UTF-8 must tell it by a byte order mark or its XML declaration.
+// A malformed document is parsed again once repaired, the repairs being reported in the result,
+// and the error of the original document is returned when the repaired one fails too.
+func (r Rss) ParseFeed(body []byte) (repository.FetchResult, error) {
+	body, err := toUTF8(body, "")
+	if err != nil {
+		return repository.FetchResult{}, err
+	}
+	parsed, body, repairs, err := parseRepaired(r.Parser, body)
+	if err != nil {
+		return repository.FetchResult{}, err
+	}
+	result := repository.FetchResult{
+		Title:    parsed.Title,
+		SiteLink: parsed.Link,
+		Items:    parsed.Items,
+		Repairs:  repairs,
+	}
+	if parsed.Image != nil {
+		result.IconURL = parsed.Image.URL
+	}
+	if parsed.FeedType == "rss" {
+		result.TTL, result.SkipHours, result.SkipDays = rssHints(body)
+	}
+	result.HubURL, result.SelfURL = hubLinks(parsed, body)
+	return result, nil
 }
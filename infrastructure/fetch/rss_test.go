@@ -1,17 +1,387 @@
 package fetch_test
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/google/go-cmp/cmp"
 )
 
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>example</title>
+<link>https://example.com/</link>
+<image><url>https://example.com/icon.png</url><title>example</title><link>https://example.com/</link></image>
+<item><title>title1</title><link>https://example.com/entry1</link><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item>
+</channel>
+</rss>`
+
 func TestRssFetch(t *testing.T) {
-	got, err := fetch.NewRss().Fetch("https://example.com/")
+	got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: "https://example.com/"})
 	if err == nil {
 		t.Errorf("want: error, got: nil")
 	}
-	if got != nil {
-		t.Errorf("want: nil, got: %v", got)
+	if got.Items != nil {
+		t.Errorf("want: nil, got: %v", got.Items)
+	}
+}
+
+func TestRssFetchConditional(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name            string
+		args            model.Feed
+		wantItems       int
+		wantNotModified bool
+	}{
+		{
+			name:      "without validators",
+			args:      model.Feed{URL: srv.URL},
+			wantItems: 1,
+		},
+		{
+			name:            "not modified",
+			args:            model.Feed{URL: srv.URL, ETag: etag, LastModified: lastModified},
+			wantItems:       0,
+			wantNotModified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("want: nil, got: %v", err)
+			}
+			if len(got.Items) != tt.wantItems {
+				t.Errorf("want: %d, got: %d", tt.wantItems, len(got.Items))
+			}
+			if got.NotModified != tt.wantNotModified {
+				t.Errorf("want: %v, got: %v", tt.wantNotModified, got.NotModified)
+			}
+			if !tt.wantNotModified && (got.Title != "example" || got.SiteLink != "https://example.com/" || got.IconURL != "https://example.com/icon.png") {
+				t.Errorf("want: example https://example.com/ https://example.com/icon.png, got: %s %s %s", got.Title, got.SiteLink, got.IconURL)
+			}
+			if got.ETag != etag || got.LastModified != lastModified {
+				t.Errorf("want: %s %s, got: %s %s", etag, lastModified, got.ETag, got.LastModified)
+			}
+		})
+	}
+}
+
+func TestRssFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name:    "per feed timeout",
+			timeout: 10 * time.Millisecond,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+		},
+		{
+			name:    "caller deadline",
+			timeout: 0,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			_, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, tt.timeout).Fetch(ctx, model.Feed{URL: srv.URL})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("want: %v, got: %v", context.DeadlineExceeded, err)
+			}
+		})
+	}
+}
+
+func TestRssFetchHints(t *testing.T) {
+	const hintedFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>example</title>
+<ttl>60</ttl>
+<skipHours><hour>0</hour><hour>24</hour><hour>3</hour></skipHours>
+<skipDays><day>Sunday</day><day>Someday</day></skipDays>
+<item><title>title1</title><link>https://example.com/entry1</link></item>
+</channel>
+</rss>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/not-modified":
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("Cache-Control", "max-age=600")
+			_, _ = w.Write([]byte(hintedFeed))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    repository.FetchResult
+		wantErr bool
+	}{
+		{
+			name: "rss channel",
+			path: "/",
+			want: repository.FetchResult{TTL: time.Hour, SkipHours: []int{0, 0, 3}, SkipDays: []time.Weekday{time.Sunday}, MaxAge: 10 * time.Minute},
+		},
+		{
+			name: "not modified",
+			path: "/not-modified",
+			want: repository.FetchResult{MaxAge: 5 * time.Minute},
+		},
+		{
+			name:    "retry after",
+			path:    "/busy",
+			want:    repository.FetchResult{RetryAfter: 2 * time.Minute},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL + tt.path})
+
+			// assert
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error: %v, got: %v", tt.wantErr, err)
+			}
+			hints := repository.FetchResult{TTL: got.TTL, SkipHours: got.SkipHours, SkipDays: got.SkipDays, MaxAge: got.MaxAge, RetryAfter: got.RetryAfter}
+			if diff := cmp.Diff(hints, tt.want); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestRssFetchRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/permanent", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.Handle("/permanent-308", http.RedirectHandler("/feed", http.StatusPermanentRedirect))
+	mux.Handle("/temporary", http.RedirectHandler("/feed", http.StatusFound))
+	mux.Handle("/permanent-then-temporary", http.RedirectHandler("/temporary", http.StatusMovedPermanently))
+	mux.Handle("/temporary-then-permanent", http.RedirectHandler("/permanent", http.StatusFound))
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "no redirect", path: "/feed", want: ""},
+		{name: "permanent", path: "/permanent", want: srv.URL + "/feed"},
+		{name: "permanent 308", path: "/permanent-308", want: srv.URL + "/feed"},
+		{name: "temporary", path: "/temporary", want: ""},
+		{name: "permanent then temporary", path: "/permanent-then-temporary", want: srv.URL + "/temporary"},
+		{name: "temporary then permanent", path: "/temporary-then-permanent", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL + tt.path})
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			if got.MovedTo != tt.want {
+				t.Errorf("want: %s, got: %s", tt.want, got.MovedTo)
+			}
+		})
+	}
+}
+
+func TestRssFetchOptions(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		options  model.FetchOptions
+		defaults model.FetchOptions
+		want     map[string]string
+	}{
+		{
+			name:    "headers and token",
+			options: model.FetchOptions{Headers: map[string]string{"X-Api-Key": "key"}, Token: "secret", UserAgent: "bot/1.0"},
+			want:    map[string]string{"X-Api-Key": "key", "Authorization": "Bearer secret", "User-Agent": "bot/1.0"},
+		},
+		{
+			name:    "basic auth",
+			options: model.FetchOptions{Username: "user", Password: "pass"},
+			want:    map[string]string{"Authorization": "Basic dXNlcjpwYXNz", "User-Agent": "Gofeed/1.0"},
+		},
+		{
+			name:     "defaults",
+			options:  model.FetchOptions{Headers: map[string]string{"Accept-Language": "ja"}},
+			defaults: model.FetchOptions{Headers: map[string]string{"Accept-Language": "en", "X-Api-Key": "key"}, UserAgent: "bot/1.0"},
+			want:     map[string]string{"Accept-Language": "ja", "X-Api-Key": "key", "User-Agent": "bot/1.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			_, err := fetch.NewRss(http.DefaultClient, tt.defaults, 0).Fetch(context.Background(), model.Feed{URL: srv.URL, Options: tt.options})
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			for name, want := range tt.want {
+				if got.Get(name) != want {
+					t.Errorf("%s: want: %s, got: %s", name, want, got.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func TestRssFetchOptionsRedirect(t *testing.T) {
+	var got http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.RedirectHandler(other.URL+"/feed", http.StatusFound))
+	defer srv.Close()
+
+	// test
+	options := model.FetchOptions{Headers: map[string]string{"X-Api-Key": "key"}, Token: "secret"}
+	_, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL, Options: options})
+
+	// assert
+	if err != nil {
+		t.Errorf("want: nil, got: %v", err)
+	}
+	if got.Get("X-Api-Key") != "" || got.Get("Authorization") != "" {
+		t.Errorf("want: no secret sent to another host, got: %v", got)
+	}
+}
+
+func TestRssFetchProxy(t *testing.T) {
+	var got string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute URL of the target
+		got = r.URL.String()
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer proxy.Close()
+
+	// test
+	feed := model.Feed{URL: "http://feed.invalid/feed", Options: model.FetchOptions{Proxy: proxy.URL}}
+	result, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), feed)
+
+	// assert
+	if err != nil {
+		t.Errorf("want: nil, got: %v", err)
+	}
+	if got != feed.URL {
+		t.Errorf("want: %s, got: %s", feed.URL, got)
+	}
+	if result.Title != "example" {
+		t.Errorf("want: example, got: %s", result.Title)
+	}
+}
+
+func TestRssFetchCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		contentType string
+		wantTitle   string
+		wantItem    string
+	}{
+		{name: "Shift_JIS declared", file: "shift_jis.xml", contentType: "application/rss+xml", wantTitle: "日本語のフィード", wantItem: "新着記事のお知らせ"},
+		{name: "Shift_JIS served as UTF-8", file: "shift_jis.xml", contentType: "application/rss+xml; charset=UTF-8", wantTitle: "日本語のフィード", wantItem: "新着記事のお知らせ"},
+		{name: "EUC-JP declared", file: "euc-jp.xml", contentType: "text/xml", wantTitle: "日本語のフィード", wantItem: "新着記事のお知らせ"},
+		{name: "ISO-8859-1 in the header", file: "iso-8859-1.xml", contentType: "text/xml; charset=ISO-8859-1", wantTitle: "Café crème", wantItem: "Déjà vu à Paris"},
+		{name: "Windows-1252 declared", file: "windows-1252.xml", contentType: "text/xml", wantTitle: "“Quoted” feed", wantItem: "Price: 5€ – today"},
+		{name: "UTF-16 with a byte order mark", file: "utf-16le.xml", contentType: "text/xml", wantTitle: "日本語のフィード", wantItem: "新着記事のお知らせ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			body, err := os.ReadFile("testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+
+			// test
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL})
+
+			// assert
+			if err != nil {
+				t.Fatalf("want: nil, got: %v", err)
+			}
+			if got.Title != tt.wantTitle {
+				t.Errorf("want: %s, got: %s", tt.wantTitle, got.Title)
+			}
+			if len(got.Items) != 1 || got.Items[0].Title != tt.wantItem {
+				t.Errorf("want: %s, got: %v", tt.wantItem, got.Items)
+			}
+		})
 	}
 }
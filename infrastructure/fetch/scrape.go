@@ -0,0 +1,160 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html/charset"
+)
+
+// dateLayouts are the date formats tried on the text matched by the date selector
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"2006.01.02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"2006年1月2日",
+}
+
+type Scraper struct {
+	rss Rss
+}
+
+// NewScraper returns a fetcher of pages without a feed sending its requests with client like NewRss.
+func NewScraper(client *http.Client, defaults model.FetchOptions, timeout time.Duration) Scraper {
+	return Scraper{rss: NewRss(client, defaults, timeout)}
+}
+
+// Fetch sends a conditional GET of the page like Rss.Fetch, then builds an item from every element
+// matching the item selector of feed. A page where no item matches is an error,
+// as the selectors are most likely broken by a change of the page.
+func (s Scraper) Fetch(ctx context.Context, feed model.Feed) (repository.FetchResult, error) {
+	hints, body, resp, err := s.rss.get(ctx, feed)
+	if err != nil || hints.NotModified {
+		return hints, err
+	}
+	result, err := scrape(resp.Request.URL, body, resp.Header.Get("Content-Type"), feed.Selector)
+	if err != nil {
+		return hints, err
+	}
+	result.ETag = hints.ETag
+	result.LastModified = hints.LastModified
+	result.MovedTo = hints.MovedTo
+	result.MaxAge = hints.MaxAge
+	result.RetryAfter = hints.RetryAfter
+	return result, nil
+}
+
+// scrape builds the items of the page at base. Title defaults to the text of the item,
+// Link to the item itself or its first link, and an item without a link is skipped.
+// Items are left undated when Date is empty or its text is not a known date format.
+// The page is decoded from the charset of contentType or of its meta tags.
+func scrape(base *url.URL, body []byte, contentType string, selector model.Selector) (repository.FetchResult, error) {
+	r, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return repository.FetchResult{}, err
+	}
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return repository.FetchResult{}, err
+	}
+	if href, ok := doc.Find("base[href]").Attr("href"); ok {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+	result := repository.FetchResult{
+		Title:    text(doc.Find("title").First()),
+		SiteLink: base.String(),
+		Items:    []*gofeed.Item{},
+	}
+	if href, ok := doc.Find("link[rel~='icon']").Attr("href"); ok {
+		result.IconURL = resolve(base, href)
+	}
+
+	matched := doc.Find(selector.Item)
+	if matched.Length() == 0 {
+		return repository.FetchResult{}, fmt.Errorf("no item matches the selector %q", selector.Item)
+	}
+	matched.Each(func(_ int, s *goquery.Selection) {
+		title := s
+		if selector.Title != "" {
+			title = s.Find(selector.Title).First()
+		}
+		link := s
+		switch {
+		case selector.Link != "":
+			link = s.Find(selector.Link).First()
+		case !s.Is("a[href]"):
+			link = s.Find("a[href]").First()
+		}
+		href, _ := link.Attr("href")
+		item := &gofeed.Item{Title: text(title), Link: resolve(base, strings.TrimSpace(href))}
+		if item.Link == "" {
+			return
+		}
+		if selector.Date != "" {
+			date := s.Find(selector.Date).First()
+			// a <time> element carries a machine readable date
+			item.Published = text(date)
+			if datetime, ok := date.Attr("datetime"); ok {
+				item.Published = strings.TrimSpace(datetime)
+			}
+			item.PublishedParsed = parseDate(item.Published)
+		}
+		result.Items = append(result.Items, item)
+	})
+	return result, nil
+}
+
+// text returns the text of s with its whitespace collapsed.
+func text(s *goquery.Selection) string {
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+// parseDate parses s with the first matching of dateLayouts, nil when none matches.
+func parseDate(s string) *time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// Sources fetches every feed with the fetcher of its kind.
+type Sources struct {
+	rss     Rss
+	scraper Scraper
+}
+
+func NewSources(rss Rss, scraper Scraper) Sources {
+	return Sources{rss: rss, scraper: scraper}
+}
+
+func (s Sources) Fetch(ctx context.Context, feed model.Feed) (repository.FetchResult, error) {
+	if feed.Scraped() {
+		return s.scraper.Fetch(ctx, feed)
+	}
+	return s.rss.Fetch(ctx, feed)
+}
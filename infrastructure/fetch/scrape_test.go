@@ -0,0 +1,159 @@
+package fetch_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/mmcdole/gofeed"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+<title> Example
+ news </title>
+<link rel="shortcut icon" href="/favicon.ico">
+</head>
+<body>
+<ul class="news">
+<li><a href="/news/1"><span class="title">First</span></a><time datetime="2024-01-02T03:04:05Z">Jan 2</time></li>
+<li><a href="https://other.example.com/2"><span class="title">Second</span></a><span class="date">2024/01/01</span></li>
+<li><span class="title">No link</span></li>
+</ul>
+<a class="plain" href="/plain">Plain
+ link</a>
+</body>
+</html>`
+
+func TestScraperFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		selector model.Selector
+		want     []*gofeed.Item
+		wantErr  bool
+	}{
+		{
+			name:     "title link and date",
+			selector: model.Selector{Item: ".news li", Title: ".title", Link: "a", Date: "time, .date"},
+			want: []*gofeed.Item{
+				{Title: "First", Link: srv.URL + "/news/1", Published: "2024-01-02T03:04:05Z", PublishedParsed: &first},
+				{Title: "Second", Link: "https://other.example.com/2", Published: "2024/01/01", PublishedParsed: &second},
+			},
+		},
+		{
+			name:     "item is the link",
+			selector: model.Selector{Item: "a.plain"},
+			want: []*gofeed.Item{
+				{Title: "Plain link", Link: srv.URL + "/plain"},
+			},
+		},
+		{
+			name:     "first link of the item",
+			selector: model.Selector{Item: ".news li", Title: ".title"},
+			want: []*gofeed.Item{
+				{Title: "First", Link: srv.URL + "/news/1"},
+				{Title: "Second", Link: "https://other.example.com/2"},
+			},
+		},
+		{
+			name:     "no item matches",
+			selector: model.Selector{Item: "article"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := fetch.NewScraper(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL + "/news", Selector: tt.selector})
+
+			// assert
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error: %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Title != "Example news" || got.SiteLink != srv.URL+"/news" || got.IconURL != srv.URL+"/favicon.ico" {
+				t.Errorf("want: page metadata, got: %s %s %s", got.Title, got.SiteLink, got.IconURL)
+			}
+			if diff := cmp.Diff(got.Items, tt.want, cmpopts.IgnoreUnexported(gofeed.Item{})); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+		})
+	}
+}
+
+func TestScraperFetchCharset(t *testing.T) {
+	body, err := os.ReadFile("testdata/shift_jis.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	// test
+	got, err := fetch.NewScraper(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL, Selector: model.Selector{Item: ".news a"}})
+
+	// assert
+	if err != nil {
+		t.Fatalf("want: nil, got: %v", err)
+	}
+	if got.Title != "お知らせ" || len(got.Items) != 1 || got.Items[0].Title != "新着記事のお知らせ" {
+		t.Errorf("want: お知らせ 新着記事のお知らせ, got: %s %v", got.Title, got.Items)
+	}
+}
+
+func TestSourcesFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			_, _ = w.Write([]byte(testFeed))
+			return
+		}
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+	s := fetch.NewSources(fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0), fetch.NewScraper(http.DefaultClient, model.FetchOptions{}, 0))
+
+	tests := []struct {
+		name string
+		feed model.Feed
+		want string
+	}{
+		{name: "feed", feed: model.Feed{URL: srv.URL + "/feed"}, want: "title1"},
+		{name: "scraped page", feed: model.Feed{URL: srv.URL + "/news", Selector: model.Selector{Item: "a.plain"}}, want: "Plain link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := s.Fetch(context.Background(), tt.feed)
+
+			// assert
+			if err != nil {
+				t.Fatalf("want: nil, got: %v", err)
+			}
+			if len(got.Items) != 1 || got.Items[0].Title != tt.want {
+				t.Errorf("want: %s, got: %v", tt.want, got.Items)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+)
+
+// hubLinks returns the WebSub hub and self links of a feed document.
+// RSS channels carry them as atom:link extensions.
+func hubLinks(parsed *gofeed.Feed, body []byte) (hub, self string) {
+	switch parsed.FeedType {
+	case "atom":
+		feed, err := (&atom.Parser{}).Parse(bytes.NewReader(body))
+		if err != nil {
+			return "", ""
+		}
+		for _, link := range feed.Links {
+			switch link.Rel {
+			case "hub":
+				hub = first(hub, link.Href)
+			case "self":
+				self = first(self, link.Href)
+			}
+		}
+	case "rss":
+		for _, link := range parsed.Extensions["atom"]["link"] {
+			switch link.Attrs["rel"] {
+			case "hub":
+				hub = first(hub, link.Attrs["href"])
+			case "self":
+				self = first(self, link.Attrs["href"])
+			}
+		}
+	}
+	return hub, self
+}
+
+// linkHeader returns the hub and self links of a Link header.
+func linkHeader(h http.Header) (hub, self string) {
+	for _, value := range h.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			for _, param := range strings.Split(params, ";") {
+				name, rels, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(name, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(rels, `"`)) {
+					switch strings.ToLower(rel) {
+					case "hub":
+						hub = first(hub, target)
+					case "self":
+						self = first(self, target)
+					}
+				}
+			}
+		}
+	}
+	return hub, self
+}
+
+func first(current, candidate string) string {
+	if current != "" {
+		return current
+	}
+	return candidate
+}
+
+// resolve makes ref absolute against base, leaving an empty ref empty.
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+type Hub struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewHub returns a client of WebSub hubs sending its requests with client, cancelled after timeout.
+func NewHub(client *http.Client, timeout time.Duration) Hub {
+	return Hub{client: client, timeout: timeout}
+}
+
+// Subscribe sends a subscription request to hub. The hub verifies it asynchronously through the callback.
+func (h Hub) Subscribe(ctx context.Context, hub string, req repository.HubRequest) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	form := url.Values{
+		"hub.mode":     {req.Mode},
+		"hub.topic":    {req.Topic},
+		"hub.callback": {req.Callback},
+	}
+	if req.Secret != "" {
+		form.Set("hub.secret", req.Secret)
+	}
+	if req.Lease > 0 {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(req.Lease.Seconds())))
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := h.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hub %s refused the %s request: %s", hub, req.Mode, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package fetch_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRssFetchHubLinks(t *testing.T) {
+	const atomFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>example</title>
+<link rel="hub" href="https://hub.example.com/"/>
+<link rel="self" href="/atom"/>
+<entry><title>title1</title><link href="https://example.com/entry1"/><id>1</id><updated>2006-01-02T15:04:05Z</updated></entry>
+</feed>`
+	const rssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+<title>example</title>
+<atom:link rel="hub" href="https://hub.example.com/"/>
+<atom:link rel="self" href="https://example.com/rss"/>
+<item><title>title1</title><link>https://example.com/entry1</link></item>
+</channel>
+</rss>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/atom":
+			_, _ = w.Write([]byte(atomFeed))
+		case "/rss":
+			_, _ = w.Write([]byte(rssFeed))
+		case "/header":
+			w.Header().Add("Link", `<https://hub.example.com/header>; rel="hub", <https://example.com/header>; rel="self"`)
+			_, _ = w.Write([]byte(rssFeed))
+		default:
+			_, _ = w.Write([]byte(testFeed))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantHub  string
+		wantSelf string
+	}{
+		{name: "atom", path: "/atom", wantHub: "https://hub.example.com/", wantSelf: srv.URL + "/atom"},
+		{name: "rss atom link", path: "/rss", wantHub: "https://hub.example.com/", wantSelf: "https://example.com/rss"},
+		{name: "link header", path: "/header", wantHub: "https://hub.example.com/header", wantSelf: "https://example.com/header"},
+		{name: "no hub", path: "/", wantHub: "", wantSelf: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := fetch.NewRss(http.DefaultClient, model.FetchOptions{}, 0).Fetch(context.Background(), model.Feed{URL: srv.URL + tt.path})
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			if got.HubURL != tt.wantHub || got.SelfURL != tt.wantSelf {
+				t.Errorf("want: %s %s, got: %s %s", tt.wantHub, tt.wantSelf, got.HubURL, got.SelfURL)
+			}
+		})
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		got = r.PostForm
+		if r.PostForm.Get("hub.topic") == "https://example.com/refused" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		req     repository.HubRequest
+		want    url.Values
+		wantErr bool
+	}{
+		{
+			name: "accepted",
+			req:  repository.HubRequest{Mode: "subscribe", Topic: "https://example.com/feed", Callback: "https://bot.example.com/websub/1", Secret: "secret", Lease: 24 * time.Hour},
+			want: url.Values{
+				"hub.mode":          {"subscribe"},
+				"hub.topic":         {"https://example.com/feed"},
+				"hub.callback":      {"https://bot.example.com/websub/1"},
+				"hub.secret":        {"secret"},
+				"hub.lease_seconds": {"86400"},
+			},
+		},
+		{
+			name: "refused",
+			req:  repository.HubRequest{Mode: "subscribe", Topic: "https://example.com/refused", Callback: "https://bot.example.com/websub/1"},
+			want: url.Values{
+				"hub.mode":     {"subscribe"},
+				"hub.topic":    {"https://example.com/refused"},
+				"hub.callback": {"https://bot.example.com/websub/1"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			err := fetch.NewHub(http.DefaultClient, 0).Subscribe(context.Background(), srv.URL, tt.req)
+
+			// assert
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error: %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+		})
+	}
+}
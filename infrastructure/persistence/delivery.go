@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type deliveryPersistence struct {
+	db *gorm.DB
+}
+
+func NewDeliveryPersistence(db *gorm.DB) repository.DeliveryRepository {
+	return &deliveryPersistence{db: db}
+}
+
+func (d deliveryPersistence) Find(subscriptionID, entryID uint) (model.Delivery, error) {
+	var delivery model.Delivery
+	err := d.db.Where("subscription_id = ? AND entry_id = ?", subscriptionID, entryID).Take(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Delivery{}, repository.ErrNotFound
+	}
+	return delivery, err
+}
+
+func (d deliveryPersistence) Save(delivery model.Delivery) (model.Delivery, error) {
+	err := d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subscription_id"}, {Name: "entry_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "action", "nonce", "attempts", "last_error", "message_id", "next_attempt_at", "updated_at"}),
+	}).Create(&delivery).Error
+	if err != nil {
+		return model.Delivery{}, err
+	}
+	// MySQL does not return the ID of the row an upsert updates
+	err = d.db.Model(&model.Delivery{}).Select("id").Where("subscription_id = ? AND entry_id = ?", delivery.SubscriptionID, delivery.EntryID).Scan(&delivery.ID).Error
+	return delivery, err
+}
+
+func (d deliveryPersistence) FindDue(now time.Time, maxAttempts int) ([]model.Delivery, error) {
+	var deliveries []model.Delivery
+	err := d.db.Where("status IN ? AND next_attempt_at <= ? AND attempts < ?", []string{model.DeliveryPending, model.DeliveryFailed}, now, maxAttempts).
+		Order("id").
+		Find(&deliveries).Error
+	if err != nil {
+		return []model.Delivery{}, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,107 @@
+package persistence_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeliveryPersistence(t *testing.T) {
+	tests := []struct {
+		name    string
+		saves   []model.Delivery
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "never delivered",
+			saves:   []model.Delivery{{SubscriptionID: 1, EntryID: 2, MessageID: "100"}},
+			wantErr: repository.ErrNotFound,
+		},
+		{
+			name:  "delivered",
+			saves: []model.Delivery{{SubscriptionID: 1, EntryID: 1, MessageID: "100"}},
+			want:  "100",
+		},
+		{
+			name: "reposted",
+			saves: []model.Delivery{
+				{SubscriptionID: 1, EntryID: 1, MessageID: "100"},
+				{SubscriptionID: 1, EntryID: 1, MessageID: "200"},
+			},
+			want: "200",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			d := persistence.NewDeliveryPersistence(db)
+
+			// prepare
+			for _, delivery := range tt.saves {
+				if _, err := d.Save(delivery); err != nil {
+					t.Fatalf("error: %v", err)
+				}
+			}
+
+			// test
+			got, err := d.Find(1, 1)
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if got.MessageID != tt.want {
+				t.Errorf("want: %v, got: %v", tt.want, got.MessageID)
+			}
+		})
+	}
+}
+
+func TestDeliveryPersistenceFindDue(t *testing.T) {
+	now := databasetest.Now()
+	saves := []model.Delivery{
+		{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryFailed, Attempts: 1, NextAttemptAt: now.Add(-time.Minute)},
+		{SubscriptionID: 1, EntryID: 2, Status: model.DeliveryPending, NextAttemptAt: now.Add(-time.Minute)},
+		{SubscriptionID: 1, EntryID: 3, Status: model.DeliveryFailed, Attempts: 1, NextAttemptAt: now.Add(time.Minute)},
+		{SubscriptionID: 1, EntryID: 4, Status: model.DeliveryFailed, Attempts: 3, NextAttemptAt: now.Add(-time.Minute)},
+		{SubscriptionID: 1, EntryID: 5, Status: model.DeliverySent, MessageID: "100"},
+	}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	d := persistence.NewDeliveryPersistence(db)
+
+	// prepare
+	for _, delivery := range saves {
+		if _, err := d.Save(delivery); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	// test
+	got, err := d.FindDue(now, 3)
+
+	// assert
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	entries := []uint{}
+	for _, delivery := range got {
+		entries = append(entries, delivery.EntryID)
+	}
+	if !cmp.Equal(entries, []uint{1, 2}) {
+		t.Errorf("Diff: %v", cmp.Diff(entries, []uint{1, 2}))
+	}
+}
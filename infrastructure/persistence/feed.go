@@ -0,0 +1,145 @@
+package persistence
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"gorm.io/gorm"
+)
+
+type feedPersistence struct {
+	db *gorm.DB
+}
+
+func NewFeedPersistence(db *gorm.DB) repository.FeedRepository {
+	return &feedPersistence{db: db}
+}
+
+// webSubColumns are owned by the WebSub subscriber, so a poll saving a stale feed never overwrites them
+var webSubColumns = []string{"WebSubSecret", "WebSubRequestedAt", "WebSubExpiresAt"}
+
+// documentColumns are those a pushed document tells, leaving the fetch state and the schedule to the polls
+var documentColumns = []string{"Title", "SiteLink", "IconURL", "Repairs"}
+
+func (f feedPersistence) Find(id uint) (model.Feed, error) {
+	var feed model.Feed
+	err := f.db.First(&feed, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Feed{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return model.Feed{}, err
+	}
+	return feed, nil
+}
+
+func (f feedPersistence) FindBySource(feed model.Feed) (model.Feed, error) {
+	cond, err := source(feed)
+	if err != nil {
+		return model.Feed{}, err
+	}
+	err = f.db.Where("url_hash = ?", database.KeyHash(feed.URL)).Where(cond).First(&feed).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Feed{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return model.Feed{}, err
+	}
+	return feed, nil
+}
+
+// FindOrCreate returns the feed with the same URL, selector and options, creating it from feed when it does not exist.
+func (f feedPersistence) FindOrCreate(feed model.Feed) (model.Feed, error) {
+	cond, err := source(feed)
+	if err != nil {
+		return model.Feed{}, err
+	}
+	feed.OptionsDigest = cond["options_digest"].(string)
+	var found model.Feed
+	err = f.db.Where("url_hash = ?", database.KeyHash(feed.URL)).Where(cond).Attrs(feed).FirstOrCreate(&found).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// created by a concurrent subscribe
+		return f.FindBySource(feed)
+	}
+	if err != nil {
+		return model.Feed{}, err
+	}
+	return found, nil
+}
+
+// source matches the feed of the URL, selector and options of feed,
+// including the empty selectors a struct condition would skip.
+// The queries also match the hash of the URL, indexed in its place, through a condition FirstOrCreate does not copy.
+func source(feed model.Feed) (map[string]any, error) {
+	digest, err := database.Digest(feed.Options)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"url":            feed.URL,
+		"selector_item":  feed.Selector.Item,
+		"selector_title": feed.Selector.Title,
+		"selector_link":  feed.Selector.Link,
+		"selector_date":  feed.Selector.Date,
+		"options_digest": digest,
+	}, nil
+}
+
+func (f feedPersistence) Save(feeds []model.Feed) error {
+	if len(feeds) == 0 {
+		return nil
+	}
+	return f.db.Omit(webSubColumns...).Save(&feeds).Error
+}
+
+func (f feedPersistence) UpdateWebSub(feed model.Feed) error {
+	return f.db.Model(&feed).Select(webSubColumns).Updates(feed).Error
+}
+
+func (f feedPersistence) UpdateDocument(feed model.Feed) error {
+	return f.db.Model(&feed).Select(documentColumns).Updates(feed).Error
+}
+
+func (f feedPersistence) Merge(from, into uint) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		// the channels subscribed to both feeds keep the subscription to into, the deliveries of the other go with it
+		var duplicates []uint
+		subscribed := tx.Model(&model.Subscription{}).Select("channel_id").Where("feed_id = ?", into)
+		if err := tx.Model(&model.Subscription{}).Where("feed_id = ? AND channel_id IN (?)", from, subscribed).Pluck("id", &duplicates).Error; err != nil {
+			return err
+		}
+		for batch := range slices.Chunk(duplicates, batchSize) {
+			if err := tx.Where("subscription_id IN ?", batch).Delete(&model.Delivery{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", batch).Delete(&model.Subscription{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&model.Subscription{}).Where("feed_id = ?", from).Update("feed_id", into).Error; err != nil {
+			return err
+		}
+		// the entries both feeds hold are kept as into has them, along with their deliveries
+		var shared []uint
+		known := tx.Table("rss_entries AS known").Select("1").
+			Where("known.feed_id = ? AND known.identity_hash = rss_entries.identity_hash AND known.identity = rss_entries.identity", into)
+		if err := tx.Model(&model.RssEntry{}).Where("feed_id = ? AND EXISTS (?)", from, known).Pluck("id", &shared).Error; err != nil {
+			return err
+		}
+		for batch := range slices.Chunk(shared, batchSize) {
+			if err := tx.Where("entry_id IN ?", batch).Delete(&model.Delivery{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", batch).Delete(&model.RssEntry{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&model.RssEntry{}).Where("feed_id = ?", from).Update("feed_id", into).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Feed{}, from).Error
+	})
+}
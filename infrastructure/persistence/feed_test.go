@@ -0,0 +1,370 @@
+package persistence_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func TestFeedPersistenceFindBySource(t *testing.T) {
+	now := databasetest.Now()
+	test := []struct {
+		name    string
+		args    string
+		sel     model.Selector
+		opts    model.FetchOptions
+		create  func(*gorm.DB)
+		want    model.Feed
+		wantErr error
+	}{
+		{
+			name:    "not found",
+			args:    "https://example.com/1",
+			create:  func(db *gorm.DB) {},
+			want:    model.Feed{},
+			wantErr: repository.ErrNotFound,
+		},
+		{
+			name: "found",
+			args: "https://example.com/1",
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", CreatedAt: now, UpdatedAt: now})
+				db.Create(&model.Feed{ID: 2, URL: "https://example.com/2", CreatedAt: now, UpdatedAt: now})
+			},
+			want: model.Feed{ID: 1, URL: "https://example.com/1", CreatedAt: now, UpdatedAt: now},
+		},
+		{
+			name: "feed rather than scraped page",
+			args: "https://example.com/1",
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", Selector: model.Selector{Item: "article"}, CreatedAt: now, UpdatedAt: now})
+				db.Create(&model.Feed{ID: 2, URL: "https://example.com/1", CreatedAt: now, UpdatedAt: now})
+			},
+			want: model.Feed{ID: 2, URL: "https://example.com/1", CreatedAt: now, UpdatedAt: now},
+		},
+		{
+			name: "scraped page",
+			args: "https://example.com/1",
+			sel:  model.Selector{Item: "article", Title: "h2"},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", Selector: model.Selector{Item: "article"}, CreatedAt: now, UpdatedAt: now})
+				db.Create(&model.Feed{ID: 2, URL: "https://example.com/1", Selector: model.Selector{Item: "article", Title: "h2"}, CreatedAt: now, UpdatedAt: now})
+			},
+			want: model.Feed{ID: 2, URL: "https://example.com/1", Selector: model.Selector{Item: "article", Title: "h2"}, CreatedAt: now, UpdatedAt: now},
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			fr := persistence.NewFeedPersistence(db)
+
+			// prepare
+			tt.create(db)
+
+			// test
+			got, err := fr.FindBySource(model.Feed{URL: tt.args, Selector: tt.sel, Options: tt.opts})
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func TestFeedPersistenceFindOrCreate(t *testing.T) {
+	now := databasetest.Now()
+	test := []struct {
+		name   string
+		args   model.Feed
+		create func(*gorm.DB)
+		want   []model.Feed
+	}{
+		{
+			name:   "create",
+			args:   model.Feed{URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now},
+			create: func(db *gorm.DB) {},
+			want: []model.Feed{
+				{ID: 1, URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now},
+			},
+		},
+		{
+			name: "find",
+			args: model.Feed{URL: "https://example.com/1", Title: "new title"},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now})
+			},
+			want: []model.Feed{
+				{ID: 1, URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now},
+			},
+		},
+		{
+			name: "create scraped page of a feed URL",
+			args: model.Feed{URL: "https://example.com/1", Selector: model.Selector{Item: "article"}, CreatedAt: now, UpdatedAt: now},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now})
+			},
+			want: []model.Feed{
+				{ID: 1, URL: "https://example.com/1", Title: "example", CreatedAt: now, UpdatedAt: now},
+				{ID: 2, URL: "https://example.com/1", Selector: model.Selector{Item: "article"}, CreatedAt: now, UpdatedAt: now},
+			},
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			fr := persistence.NewFeedPersistence(db)
+
+			// prepare
+			tt.create(db)
+
+			// test
+			got, err := fr.FindOrCreate(tt.args)
+
+			all := []model.Feed{}
+			db.Find(&all)
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			// the feed found or created is the last one
+			if want := tt.want[len(tt.want)-1]; !cmp.Equal(got, want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, want))
+			}
+			if !cmp.Equal(all, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(all, tt.want))
+			}
+		})
+	}
+}
+
+func TestFeedPersistenceOptions(t *testing.T) {
+	// setup
+	if err := database.SetEncryptionKey([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	fr := persistence.NewFeedPersistence(db)
+	options := model.FetchOptions{Username: "user", Password: "p4ssw0rd", Headers: map[string]string{"X-Api-Key": "k3y"}}
+
+	// test
+	plain, err := fr.FindOrCreate(model.Feed{URL: "https://example.com/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := fr.FindOrCreate(model.Feed{URL: "https://example.com/1", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := fr.FindBySource(model.Feed{URL: "https://example.com/1", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherErr := fr.FindBySource(model.Feed{URL: "https://example.com/1", Options: model.FetchOptions{Username: "user", Password: "other"}})
+	var raw string
+	db.Raw("SELECT options FROM feeds WHERE id = ?", created.ID).Scan(&raw)
+
+	// assert
+	if plain.ID == created.ID {
+		t.Errorf("want: a feed with options apart from the plain one, got: the same feed %d", plain.ID)
+	}
+	if found.ID != created.ID || !cmp.Equal(found.Options, options) {
+		t.Errorf("want: %d %v, got: %d %v", created.ID, options, found.ID, found.Options)
+	}
+	if !errors.Is(otherErr, repository.ErrNotFound) {
+		t.Errorf("want: %v, got: %v", repository.ErrNotFound, otherErr)
+	}
+	if raw == "" || strings.Contains(raw, "p4ssw0rd") || strings.Contains(raw, "k3y") {
+		t.Errorf("want: options encrypted at rest, got: %q", raw)
+	}
+}
+
+func TestFeedPersistenceSave(t *testing.T) {
+	now := databasetest.Now()
+	test := []struct {
+		name   string
+		args   []model.Feed
+		create func(*gorm.DB)
+		want   []model.Feed
+	}{
+		{
+			name:   "empty",
+			args:   []model.Feed{},
+			create: func(db *gorm.DB) {},
+			want:   []model.Feed{},
+		},
+		{
+			name: "update fetch state",
+			args: []model.Feed{{ID: 1, URL: "https://example.com/1", Title: "new title", ETag: `"2"`, CreatedAt: now}},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", Title: "example", ETag: `"1"`, CreatedAt: now})
+				db.Create(&model.Feed{ID: 2, URL: "https://example.com/2", Title: "example", ETag: `"1"`, CreatedAt: now})
+			},
+			want: []model.Feed{
+				{ID: 1, URL: "https://example.com/1", Title: "new title", ETag: `"2"`, CreatedAt: now},
+				{ID: 2, URL: "https://example.com/2", Title: "example", ETag: `"1"`, CreatedAt: now},
+			},
+		},
+		{
+			name: "moved",
+			args: []model.Feed{{ID: 1, URL: "https://example.com/moved", CreatedAt: now}},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", CreatedAt: now})
+			},
+			want: []model.Feed{{ID: 1, URL: "https://example.com/moved", CreatedAt: now}},
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			fr := persistence.NewFeedPersistence(db)
+
+			// prepare
+			tt.create(db)
+
+			// test
+			err := fr.Save(tt.args)
+
+			got := []model.Feed{}
+			db.Find(&got)
+
+			// remove UpdatedAt
+			for i := range got {
+				got[i].UpdatedAt = time.Time{}
+			}
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want))
+			}
+			// the hash indexed in place of the URL follows it
+			for _, feed := range tt.want {
+				if found, err := fr.FindBySource(model.Feed{URL: feed.URL}); err != nil || found.ID != feed.ID {
+					t.Errorf("want: feed %d found by its URL, got: %v, %v", feed.ID, found.ID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFeedPersistenceMerge(t *testing.T) {
+	now := databasetest.Now()
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	fr := persistence.NewFeedPersistence(db)
+
+	// prepare
+	db.Create(&model.Feed{ID: 1, URL: "https://example.com/old", CreatedAt: now})
+	db.Create(&model.Feed{ID: 2, URL: "https://example.com/new", CreatedAt: now})
+	db.Create(&model.Subscription{ID: 1, ChannelID: "123", FeedID: 1, CreatedAt: now})
+	db.Create(&model.Subscription{ID: 2, ChannelID: "456", FeedID: 1, CreatedAt: now})
+	db.Create(&model.Subscription{ID: 3, ChannelID: "456", FeedID: 2, CreatedAt: now})
+	db.Create(&model.RssEntry{ID: 1, FeedID: 1, Identity: "guid:1", EntryLink: "https://example.com/entry1", PublishedAt: now})
+	db.Create(&model.RssEntry{ID: 2, FeedID: 1, Identity: "guid:2", EntryLink: "https://example.com/entry2", PublishedAt: now})
+	db.Create(&model.RssEntry{ID: 3, FeedID: 2, Identity: "guid:2", EntryLink: "https://example.com/entry2", PublishedAt: now})
+	db.Create(&model.Delivery{SubscriptionID: 1, EntryID: 2, Status: model.DeliverySent})
+	db.Create(&model.Delivery{SubscriptionID: 2, EntryID: 1, Status: model.DeliveryPending})
+
+	// test
+	err := fr.Merge(1, 2)
+
+	feeds := []model.Feed{}
+	db.Find(&feeds)
+	subs := []model.Subscription{}
+	db.Find(&subs)
+	entries := []model.RssEntry{}
+	db.Order("id").Find(&entries)
+	deliveries := []model.Delivery{}
+	db.Find(&deliveries)
+
+	// assert
+	if err != nil {
+		t.Errorf("want: nil, got: %v", err)
+	}
+	if len(feeds) != 1 || feeds[0].ID != 2 {
+		t.Errorf("want: feed 2, got: %v", feeds)
+	}
+	wantSubs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 2, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+		{ID: 3, ChannelID: "456", FeedID: 2, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+	}
+	if !cmp.Equal(subs, wantSubs) {
+		t.Errorf("Diff: %v", cmp.Diff(subs, wantSubs))
+	}
+	gotEntries := [][2]any{}
+	for _, e := range entries {
+		gotEntries = append(gotEntries, [2]any{e.ID, e.FeedID})
+	}
+	// the entry feed 2 already holds is dropped from feed 1 instead of colliding with it
+	wantEntries := [][2]any{{uint(1), uint(2)}, {uint(3), uint(2)}}
+	if !cmp.Equal(gotEntries, wantEntries) {
+		t.Errorf("entries Diff: %v", cmp.Diff(gotEntries, wantEntries))
+	}
+	if len(deliveries) != 0 {
+		t.Errorf("want: deliveries of the dropped entry and subscription deleted, got: %v", deliveries)
+	}
+}
+
+func TestFeedPersistenceWebSub(t *testing.T) {
+	now := databasetest.Now()
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	fr := persistence.NewFeedPersistence(db)
+
+	// prepare
+	db.Create(&model.Feed{ID: 1, URL: "https://example.com/1", HubURL: "https://hub.example.com/", CreatedAt: now})
+	stale, _ := fr.Find(1)
+
+	// test
+	err := fr.UpdateWebSub(model.Feed{ID: 1, URL: "ignored", WebSubSecret: "secret", WebSubExpiresAt: now})
+	// a poll saving the feed loaded before must keep the WebSub state
+	stale.Title = "polled"
+	saveErr := fr.Save([]model.Feed{stale})
+	got, findErr := fr.Find(1)
+
+	// assert
+	for _, err := range []error{err, saveErr, findErr} {
+		if err != nil {
+			t.Errorf("want: nil, got: %v", err)
+		}
+	}
+	if got.URL != "https://example.com/1" || got.Title != "polled" || got.HubURL != "https://hub.example.com/" {
+		t.Errorf("want: fetch state saved, got: %v", got)
+	}
+	if got.WebSubSecret != "secret" || !got.WebSubExpiresAt.Equal(now) {
+		t.Errorf("want: WebSub state kept, got: %q %v", got.WebSubSecret, got.WebSubExpiresAt)
+	}
+	if _, err := fr.Find(2); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("want: %v, got: %v", repository.ErrNotFound, err)
+	}
+}
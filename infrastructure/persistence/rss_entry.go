@@ -1,9 +1,14 @@
 package persistence
 
 import (
+	"slices"
+	"time"
+
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RssEntryPersistence struct {
@@ -14,22 +19,176 @@ func NewRssEntryPersistence(db *gorm.DB) repository.RssEnrtyRepository {
 	return &RssEntryPersistence{db: db}
 }
 
-// func (r RssEntryPersistence) saveRSSEntries(rssURL string, entries []*gofeed.Item) {
+// Create saves entries, skipping those whose identity the feed already has.
 func (r RssEntryPersistence) Create(entries []model.RssEntry) error {
 	if len(entries) == 0 {
 		return nil
 	}
-	res := r.db.Create(&entries)
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entries)
 	if res.Error != nil {
 		return res.Error
 	}
 	return nil
 }
 
-func (r RssEntryPersistence) Find(entries []model.RssEntry) []model.RssEntry {
-	if len(entries) == 0 {
-		return []model.RssEntry{}
+// batchSize keeps the bound parameters of a query below the limits of the databases
+const batchSize = 500
+
+// keyHashes returns the hashes of identities, which the queries look the entries up by through their index,
+// matching the identities too against a collision of the hashes.
+func keyHashes(identities []string) []string {
+	hashes := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		hashes = append(hashes, database.KeyHash(identity))
+	}
+	return hashes
+}
+
+func (r RssEntryPersistence) FindByIdentities(feedID uint, identities []string) ([]model.RssEntry, error) {
+	res := []model.RssEntry{}
+	for batch := range slices.Chunk(identities, batchSize) {
+		var entries []model.RssEntry
+		if err := r.db.Where("feed_id = ? AND identity_hash IN ? AND identity IN ?", feedID, keyHashes(batch), batch).Order("id").Find(&entries).Error; err != nil {
+			return []model.RssEntry{}, err
+		}
+		res = append(res, entries...)
+	}
+	return res, nil
+}
+
+func (r RssEntryPersistence) FindByIDs(ids []uint) ([]model.RssEntry, error) {
+	res := []model.RssEntry{}
+	// sorted, the batches return the entries in the order of their IDs
+	for batch := range slices.Chunk(slices.Sorted(slices.Values(ids)), batchSize) {
+		var entries []model.RssEntry
+		if err := r.db.Where("id IN ?", batch).Order("id").Find(&entries).Error; err != nil {
+			return []model.RssEntry{}, err
+		}
+		res = append(res, entries...)
+	}
+	return res, nil
+}
+
+func (r RssEntryPersistence) Save(created, changed []model.RssEntry, deliveries []model.Delivery) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if len(created) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&created).Error; err != nil {
+				return err
+			}
+		}
+		// the content is updated from the struct rather than a map, so that the lists go through their serializer
+		for _, e := range changed {
+			err := tx.Model(&model.RssEntry{ID: e.ID}).
+				Select("EntryTitle", "EntryLink", "Description", "Content", "Authors", "Categories", "Enclosures", "ImageURL", "Media", "ContentHash").
+				Updates(&e).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		// the IDs set by Create are not reliable when some entries were skipped, so look them up
+		type key struct {
+			feedID   uint
+			identity string
+		}
+		ids := map[key]uint{}
+		for i, d := range deliveries {
+			if d.EntryID != 0 {
+				continue
+			}
+			k := key{feedID: d.Entry.FeedID, identity: d.Entry.Identity}
+			if _, ok := ids[k]; !ok {
+				var entry model.RssEntry
+				if err := tx.Select("id").Where("feed_id = ? AND identity_hash = ? AND identity = ?", k.feedID, database.KeyHash(k.identity), k.identity).Take(&entry).Error; err != nil {
+					return err
+				}
+				ids[k] = entry.ID
+			}
+			deliveries[i].EntryID = ids[k]
+		}
+		// requeue the deliveries already sent, then queue the others, leaving those still queued as they are.
+		// It takes two steps as MySQL can't tell which rows an upsert updates.
+		for _, d := range deliveries {
+			err := tx.Model(&model.Delivery{}).
+				Where("subscription_id = ? AND entry_id = ? AND status = ?", d.SubscriptionID, d.EntryID, model.DeliverySent).
+				Updates(map[string]any{"status": d.Status, "action": d.Action, "nonce": d.Nonce, "attempts": d.Attempts, "last_error": d.LastError, "next_attempt_at": d.NextAttemptAt}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+	})
+}
+
+func (r RssEntryPersistence) MarkSeen(identities map[uint][]string, at time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for feedID, ids := range identities {
+			for batch := range slices.Chunk(ids, batchSize) {
+				err := tx.Model(&model.RssEntry{}).Where("feed_id = ? AND identity_hash IN ? AND identity IN ?", feedID, keyHashes(batch), batch).Update("seen_at", at).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (r RssEntryPersistence) Prune(before time.Time, keep, maxAttempts int) (int, error) {
+	// an entry is live when it was seen since the whole document of its feed was last read
+	ranked := r.db.Model(&model.RssEntry{}).
+		Joins("JOIN feeds ON feeds.id = rss_entries.feed_id").
+		Select("rss_entries.id, rss_entries.created_at, rss_entries.seen_at, " +
+			"ROW_NUMBER() OVER (PARTITION BY rss_entries.feed_id ORDER BY rss_entries.published_at DESC, rss_entries.id DESC) AS n, " +
+			"feeds.live_at")
+	var ids []uint
+	err := r.db.Table("(?) AS ranked", ranked).
+		Where("seen_at < live_at AND (created_at < ? OR n > ?)", before, keep).
+		Where("NOT EXISTS (SELECT 1 FROM deliveries WHERE deliveries.entry_id = ranked.id AND deliveries.status <> ? AND deliveries.attempts < ?)", model.DeliverySent, maxAttempts).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for batch := range slices.Chunk(ids, batchSize) {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("entry_id IN ?", batch).Delete(&model.Delivery{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", batch).Delete(&model.RssEntry{}).Error
+		})
+		if err != nil {
+			return pruned, err
+		}
+		pruned += len(batch)
+	}
+	return pruned, nil
+}
+
+func (r RssEntryPersistence) RecentPublishedAt(feedIDs []uint, n int) (map[uint][]time.Time, error) {
+	res := map[uint][]time.Time{}
+	if len(feedIDs) == 0 {
+		return res, nil
+	}
+	rows := []struct {
+		FeedID      uint
+		PublishedAt time.Time
+	}{}
+	ranked := r.db.Model(&model.RssEntry{}).
+		Select("feed_id, published_at, ROW_NUMBER() OVER (PARTITION BY feed_id ORDER BY published_at DESC) AS n").
+		Where("feed_id IN ?", feedIDs)
+	err := r.db.Table("(?) AS ranked", ranked).
+		Select("feed_id, published_at").
+		Where("n <= ?", n).
+		Order("feed_id, published_at DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		res[row.FeedID] = append(res[row.FeedID], row.PublishedAt)
 	}
-	r.db.Find(&entries)
-	return entries
+	return res, nil
 }
@@ -1,18 +1,22 @@
 package persistence_test
 
 import (
-	"os"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
 	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
 )
 
 func TestRssEntryPersistenceCreate(t *testing.T) {
-	now := time.Now()
+	now := databasetest.Now()
 	tests := []struct {
 		name string
 		args []model.RssEntry
@@ -26,25 +30,69 @@ func TestRssEntryPersistenceCreate(t *testing.T) {
 		{
 			name: "multiple",
 			args: []model.RssEntry{
-				{RSSURL: "https://example.com/", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now},
-				{RSSURL: "https://example.com/", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now},
+				{FeedID: 1, Identity: "link:https://example.com/entry1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now},
+				{FeedID: 1, Identity: "link:https://example.com/entry2", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now},
 			},
 			want: []model.RssEntry{
-				{ID: 1, RSSURL: "https://example.com/", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now, CreatedAt: time.Time{}},
-				{ID: 2, RSSURL: "https://example.com/", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now, CreatedAt: time.Time{}},
+				{ID: 1, FeedID: 1, Identity: "link:https://example.com/entry1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now, CreatedAt: time.Time{}},
+				{ID: 2, FeedID: 1, Identity: "link:https://example.com/entry2", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now, CreatedAt: time.Time{}},
+			},
+		},
+		{
+			name: "content",
+			args: []model.RssEntry{
+				{
+					FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now,
+					Description: "<p>summary</p>", Content: "<p>content</p>",
+					Authors:    []model.Author{{Name: "author", Email: "author@example.com"}},
+					Categories: []string{"go", "rss"},
+					Enclosures: []model.Enclosure{{URL: "https://example.com/1.mp3", Type: "audio/mpeg", Length: 1024}},
+					ImageURL:   "https://example.com/1.jpg",
+					Media:      []model.Media{{URL: "https://example.com/1.jpg", Medium: "image", Width: 640, Height: 480, Thumbnail: true}},
+				},
+			},
+			want: []model.RssEntry{
+				{
+					ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now,
+					Description: "<p>summary</p>", Content: "<p>content</p>",
+					Authors:    []model.Author{{Name: "author", Email: "author@example.com"}},
+					Categories: []string{"go", "rss"},
+					Enclosures: []model.Enclosure{{URL: "https://example.com/1.mp3", Type: "audio/mpeg", Length: 1024}},
+					ImageURL:   "https://example.com/1.jpg",
+					Media:      []model.Media{{URL: "https://example.com/1.jpg", Medium: "image", Width: 640, Height: 480, Thumbnail: true}},
+				},
+			},
+		},
+		{
+			// MySQL could only index a prefix of the identities
+			name: "identities sharing a long prefix",
+			args: []model.RssEntry{
+				{FeedID: 1, Identity: "link:https://example.com/" + strings.Repeat("a", 1000) + "/1", PublishedAt: now},
+				{FeedID: 1, Identity: "link:https://example.com/" + strings.Repeat("a", 1000) + "/2", PublishedAt: now},
+			},
+			want: []model.RssEntry{
+				{ID: 1, FeedID: 1, Identity: "link:https://example.com/" + strings.Repeat("a", 1000) + "/1", PublishedAt: now},
+				{ID: 2, FeedID: 1, Identity: "link:https://example.com/" + strings.Repeat("a", 1000) + "/2", PublishedAt: now},
+			},
+		},
+		{
+			name: "same identity in another feed",
+			args: []model.RssEntry{
+				{FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+				{FeedID: 2, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+				{FeedID: 1, Identity: "guid:1", EntryTitle: "title1 again", PublishedAt: now},
+			},
+			want: []model.RssEntry{
+				{ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+				{ID: 2, FeedID: 2, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
 			},
 		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			r := persistence.NewRssEntryPersistence(db)
 
@@ -69,50 +117,438 @@ func TestRssEntryPersistenceCreate(t *testing.T) {
 	}
 }
 
-func TestRssEntryPersistenceFind(t *testing.T) {
-	now := time.Now()
+func TestRssEntryPersistenceFindByIDs(t *testing.T) {
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	r := persistence.NewRssEntryPersistence(db)
+
+	// prepare
+	// more entries than a query binds IDs, asked for in reverse
+	saved := []model.RssEntry{}
+	ids := []uint{}
+	for i := range 1200 {
+		saved = append(saved, model.RssEntry{FeedID: 1, Identity: fmt.Sprintf("guid:%d", i)})
+		ids = append(ids, uint(1200-i))
+	}
+	db.CreateInBatches(saved, 100)
+
+	// test
+	got, err := r.FindByIDs(append(ids, 1201))
+
+	// assert
+	if err != nil {
+		t.Errorf("error: %v", err)
+	}
+	gotIDs := []uint{}
+	for _, e := range got {
+		gotIDs = append(gotIDs, e.ID)
+	}
+	wantIDs := slices.Sorted(slices.Values(ids))
+	if !cmp.Equal(gotIDs, wantIDs) {
+		t.Errorf("diff: %v", cmp.Diff(gotIDs, wantIDs))
+	}
+}
+
+func TestRssEntryPersistenceFindByIdentities(t *testing.T) {
+	now := databasetest.Now()
+	saved := []model.RssEntry{
+		{FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+		{FeedID: 1, Identity: "legacy:https://example.com/entry2", EntryTitle: "title2", PublishedAt: now},
+		{FeedID: 2, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+	}
+	type args struct {
+		feedID     uint
+		identities []string
+	}
 	tests := []struct {
 		name string
-		args []model.RssEntry
+		args args
 		want []model.RssEntry
 	}{
 		{
 			name: "empty",
-			args: []model.RssEntry{},
+			args: args{feedID: 1, identities: []string{}},
 			want: []model.RssEntry{},
 		},
 		{
-			name: "multiple",
-			args: []model.RssEntry{{ID: 1}},
+			name: "found in the feed only",
+			args: args{feedID: 1, identities: []string{"guid:1", "guid:2", "link:https://example.com/entry2", "legacy:https://example.com/entry2"}},
 			want: []model.RssEntry{
-				{ID: 1, RSSURL: "https://example.com/", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now},
-				{ID: 2, RSSURL: "https://example.com/", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now},
+				{ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now},
+				{ID: 2, FeedID: 1, Identity: "legacy:https://example.com/entry2", EntryTitle: "title2", PublishedAt: now},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			r := persistence.NewRssEntryPersistence(db)
+
+			// prepare
+			db.Create(slices.Clone(saved))
+
+			// test
+			got, err := r.FindByIdentities(tt.args.feedID, tt.args.identities)
+
+			// remove CreatedAt
+			for i := range got {
+				got[i].CreatedAt = time.Time{}
+			}
+
+			// assert
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func TestRssEntryPersistencePrune(t *testing.T) {
+	now := databasetest.Now()
+	old := now.Add(-48 * time.Hour)
+	seen := now.Add(-time.Hour)
+	entry := func(feedID uint, identity string, published, created, seenAt time.Time) model.RssEntry {
+		return model.RssEntry{FeedID: feedID, Identity: identity, PublishedAt: published, CreatedAt: created, SeenAt: seenAt}
+	}
+	tests := []struct {
+		name       string
+		entries    []model.RssEntry
+		deliveries []model.Delivery
+		keep       int
+		want       []string
+	}{
+		{
+			name: "too old",
+			entries: []model.RssEntry{
+				entry(1, "guid:gone", old, old, old),
+				entry(1, "guid:live", old, old, seen),
+				entry(1, "guid:recent", now, now, old),
+			},
+			keep: 10,
+			want: []string{"guid:live", "guid:recent"},
+		},
+		{
+			name: "too many",
+			entries: []model.RssEntry{
+				entry(1, "guid:1", old, now, old),
+				entry(1, "guid:2", old.Add(time.Hour), now, old),
+				entry(1, "guid:3", old.Add(2*time.Hour), now, seen),
+				entry(2, "guid:1", old, now, seen),
+			},
+			keep: 1,
+			want: []string{"guid:3", "guid:1"},
+		},
+		{
+			name: "live entries past the limit",
+			entries: []model.RssEntry{
+				entry(1, "guid:1", old, old, seen),
+				entry(1, "guid:2", now, old, seen),
+			},
+			keep: 1,
+			want: []string{"guid:1", "guid:2"},
+		},
+		{
+			name: "seen by a push since the document was read",
+			entries: []model.RssEntry{
+				entry(1, "guid:live", old, old, seen),
+				entry(1, "guid:pushed", old, old, now),
+			},
+			keep: 0,
+			want: []string{"guid:live", "guid:pushed"},
+		},
+		{
+			name: "never seen since identities were marked",
+			entries: []model.RssEntry{
+				entry(2, "legacy:https://example.com/1", old, old, time.Time{}),
+			},
+			keep: 0,
+			want: []string{"legacy:https://example.com/1"},
+		},
+		{
+			name: "not delivered yet",
+			entries: []model.RssEntry{
+				entry(1, "guid:pending", old, old, old),
+				entry(1, "guid:sent", old, old, old),
+				entry(1, "guid:live", now, now, seen),
+			},
+			deliveries: []model.Delivery{
+				{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryFailed},
+				{SubscriptionID: 1, EntryID: 2, Status: model.DeliverySent},
+			},
+			keep: 10,
+			want: []string{"guid:pending", "guid:live"},
+		},
+		{
+			name: "delivery given up",
+			entries: []model.RssEntry{
+				entry(1, "guid:given-up", old, old, old),
+				entry(1, "guid:retried", old, old, old),
+			},
+			deliveries: []model.Delivery{
+				{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryFailed, Attempts: 3},
+				{SubscriptionID: 1, EntryID: 2, Status: model.DeliveryFailed, Attempts: 2},
+			},
+			keep: 10,
+			want: []string{"guid:retried"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			r := persistence.NewRssEntryPersistence(db)
+
+			// prepare
+			// the document of feed 1 was last read when its live entries were seen, that of feed 2 never since
+			// its entries were marked
+			db.Create(&[]model.Feed{{ID: 1, URL: "https://example.com/1", LiveAt: seen}, {ID: 2, URL: "https://example.com/2"}})
+			db.Create(&tt.entries)
+			if len(tt.deliveries) > 0 {
+				db.Create(&tt.deliveries)
+			}
+
+			// test
+			_, err := r.Prune(now.Add(-24*time.Hour), tt.keep, 3)
+			entries := []model.RssEntry{}
+			db.Order("id").Find(&entries)
+			got := []string{}
+			for _, e := range entries {
+				got = append(got, e.Identity)
+			}
+			var orphans int64
+			db.Model(&model.Delivery{}).Where("entry_id NOT IN (?)", db.Model(&model.RssEntry{}).Select("id")).Count(&orphans)
+
+			// assert
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff: %v", cmp.Diff(got, tt.want))
+			}
+			if orphans > 0 {
+				t.Errorf("want: the deliveries of the pruned entries deleted, got: %d left", orphans)
+			}
+		})
+	}
+}
+
+func TestRssEntryPersistenceSave(t *testing.T) {
+	now := databasetest.Now()
+	entry := model.RssEntry{FeedID: 1, Identity: "guid:1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now}
+	queued := func(subscriptionID uint, action, nonce string) model.Delivery {
+		return model.Delivery{SubscriptionID: subscriptionID, Status: model.DeliveryPending, Action: action, Nonce: nonce, NextAttemptAt: now, Entry: entry}
+	}
+	tests := []struct {
+		name     string
+		existing []model.Delivery
+		args     []model.Delivery
+		want     []model.Delivery
+	}{
+		{
+			name: "new",
+			args: []model.Delivery{queued(1, model.DeliveryPost, "a"), queued(2, model.DeliveryPost, "b")},
+			want: []model.Delivery{
+				{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryPending, Action: model.DeliveryPost, Nonce: "a"},
+				{SubscriptionID: 2, EntryID: 1, Status: model.DeliveryPending, Action: model.DeliveryPost, Nonce: "b"},
 			},
 		},
+		{
+			name:     "sent queued again",
+			existing: []model.Delivery{{SubscriptionID: 1, EntryID: 1, Status: model.DeliverySent, Action: model.DeliveryPost, Nonce: "a", MessageID: "100"}},
+			args:     []model.Delivery{queued(1, model.DeliveryEdit, "b")},
+			want:     []model.Delivery{{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryPending, Action: model.DeliveryEdit, Nonce: "b", MessageID: "100"}},
+		},
+		{
+			name:     "not sent yet",
+			existing: []model.Delivery{{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryFailed, Action: model.DeliveryPost, Nonce: "a", Attempts: 1}},
+			args:     []model.Delivery{queued(1, model.DeliveryEdit, "b")},
+			want:     []model.Delivery{{SubscriptionID: 1, EntryID: 1, Status: model.DeliveryFailed, Action: model.DeliveryPost, Nonce: "a", Attempts: 1}},
+		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			r := persistence.NewRssEntryPersistence(db)
+
+			// prepare
+			created := []model.RssEntry{entry}
+			if len(tt.existing) > 0 {
+				db.Create(&created)
+				db.Create(&tt.existing)
+				created = nil
+			}
+
+			// test
+			err := r.Save(created, nil, tt.args)
+			got := []model.Delivery{}
+			db.Order("id").Find(&got)
+
+			// remove the IDs and times
+			for i := range got {
+				got[i].ID = 0
+				got[i].NextAttemptAt, got[i].CreatedAt, got[i].UpdatedAt = time.Time{}, time.Time{}, time.Time{}
+			}
+
+			// assert
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func TestRssEntryPersistenceSaveChanged(t *testing.T) {
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	r := persistence.NewRssEntryPersistence(db)
+	now := databasetest.Now()
+
+	// prepare
+	saved := model.RssEntry{
+		FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: now, ContentHash: "a",
+		Description: "summary", Categories: []string{"go"}, ImageURL: "https://example.com/1.jpg",
+	}
+	db.Create(&saved)
+	changed := model.RssEntry{
+		ID: saved.ID, FeedID: 1, Identity: "guid:1", EntryTitle: "title1 edited", PublishedAt: now, ContentHash: "b",
+		Description: "summary edited", Authors: []model.Author{{Name: "author"}},
+	}
+
+	// test
+	err := r.Save(nil, []model.RssEntry{changed}, nil)
+	got := model.RssEntry{}
+	db.First(&got, saved.ID)
+	got.CreatedAt = time.Time{}
+
+	// assert
+	if err != nil {
+		t.Errorf("error: %v", err)
+	}
+	if diff := cmp.Diff(got, changed); diff != "" {
+		t.Errorf("diff: %v", diff)
+	}
+}
+
+func TestRssEntryPersistenceRecentPublishedAt(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name    string
+		feedIDs []uint
+		n       int
+		want    map[uint][]time.Time
+	}{
+		{
+			name:    "empty",
+			feedIDs: []uint{},
+			n:       2,
+			want:    map[uint][]time.Time{},
+		},
+		{
+			name:    "latest n per feed",
+			feedIDs: []uint{1, 2},
+			n:       2,
+			want: map[uint][]time.Time{
+				1: {now, now.Add(-time.Hour)},
+				2: {now.Add(-3 * time.Hour)},
+			},
+		},
+	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			r := persistence.NewRssEntryPersistence(db)
 
 			// prepare
-			db.Create(tt.want)
+			db.Create([]model.RssEntry{
+				{FeedID: 1, Identity: "link:https://example.com/entry1", EntryLink: "https://example.com/entry1", PublishedAt: now.Add(-2 * time.Hour)},
+				{FeedID: 1, Identity: "link:https://example.com/entry2", EntryLink: "https://example.com/entry2", PublishedAt: now},
+				{FeedID: 1, Identity: "link:https://example.com/entry3", EntryLink: "https://example.com/entry3", PublishedAt: now.Add(-time.Hour)},
+				{FeedID: 2, Identity: "link:https://example.org/entry1", EntryLink: "https://example.org/entry1", PublishedAt: now.Add(-3 * time.Hour)},
+				{FeedID: 3, Identity: "link:https://example.net/entry1", EntryLink: "https://example.net/entry1", PublishedAt: now},
+			})
 
 			// test
-			got := r.Find(tt.args)
+			got, err := r.RecentPublishedAt(tt.feedIDs, tt.n)
 
 			// assert
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
 			if !cmp.Equal(got, tt.want) {
 				t.Errorf("diff: %v", cmp.Diff(got, tt.want))
 			}
 		})
 	}
 }
+
+func TestRssEntryPersistenceIdentityIndex(t *testing.T) {
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	if db.Dialector.Name() != "sqlite" {
+		t.Skip("the query plans are read from SQLite")
+	}
+	r := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	// the statements looking entries up by their identity, with their parameters inlined
+	statements := []string{}
+	// the subqueries are built through the callbacks as dry runs, and run as part of their query
+	capture := func(tx *gorm.DB) {
+		if sql := tx.Statement.SQL.String(); !tx.DryRun && strings.Contains(sql, "identity") {
+			statements = append(statements, tx.Dialector.Explain(sql, tx.Statement.Vars...))
+		}
+	}
+	db.Callback().Query().After("gorm:query").Register("test:capture", capture)
+	db.Callback().Update().After("gorm:update").Register("test:capture", capture)
+
+	// prepare
+	db.Create(&[]model.Feed{{ID: 1, URL: "https://example.com/1"}, {ID: 2, URL: "https://example.com/2"}})
+	entry := model.RssEntry{FeedID: 1, Identity: "guid:1"}
+	db.Create(&[]model.RssEntry{entry, {FeedID: 2, Identity: "guid:1"}})
+
+	// test
+	_, findErr := r.FindByIdentities(1, []string{"guid:1", "guid:2"})
+	seenErr := r.MarkSeen(map[uint][]string{1: {"guid:1"}}, time.Now())
+	saveErr := r.Save(nil, nil, []model.Delivery{{SubscriptionID: 1, Status: model.DeliveryPending, Entry: entry}})
+	mergeErr := fr.Merge(2, 1)
+
+	// assert
+	for _, err := range []error{findErr, seenErr, saveErr, mergeErr} {
+		if err != nil {
+			t.Errorf("error: %v", err)
+		}
+	}
+	if len(statements) != 4 {
+		t.Fatalf("want: 4 statements, got: %q", statements)
+	}
+	for _, s := range statements {
+		var plan []struct{ Detail string }
+		if err := db.Raw("EXPLAIN QUERY PLAN " + s).Scan(&plan).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(plan, func(p struct{ Detail string }) bool {
+			return strings.Contains(p.Detail, "USING INDEX idx_rss_entries_identity")
+		}) {
+			t.Errorf("want: %s through idx_rss_entries_identity, got: %v", s, plan)
+		}
+	}
+}
@@ -17,7 +17,12 @@ func NewSubscriptionPersistence(db *gorm.DB) repository.SubscriptionRepository {
 }
 
 func (s subscriptionPersistence) Create(sub model.Subscription) error {
-	return s.db.Create(&sub).Error
+	// the feed is created beforehand, so never write it through the association
+	err := s.db.Omit("Feed").Create(&sub).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return repository.ErrAlreadyExists
+	}
+	return err
 }
 
 // Deprecated: Use FindAll instead
@@ -31,7 +36,7 @@ func (s subscriptionPersistence) Find(m []model.Subscription) ([]model.Subscript
 
 func (s subscriptionPersistence) FindByModel(m model.Subscription) ([]model.Subscription, error) {
 	var subs []model.Subscription
-	res := s.db.Where(m).Find(&subs)
+	res := s.db.Preload("Feed").Where(m).Order("id").Find(&subs)
 	if res.Error != nil {
 		return []model.Subscription{}, res.Error
 	}
@@ -40,7 +45,7 @@ func (s subscriptionPersistence) FindByModel(m model.Subscription) ([]model.Subs
 
 func (s subscriptionPersistence) FindAll() ([]model.Subscription, error) {
 	var subs []model.Subscription
-	res := s.db.Find(&subs)
+	res := s.db.Preload("Feed").Order("id").Find(&subs)
 	if res.Error != nil {
 		return []model.Subscription{}, res.Error
 	}
@@ -53,5 +58,22 @@ func (s subscriptionPersistence) Delete(m model.Subscription) error {
 	if len(subs) == 0 {
 		return errors.New("record not found")
 	}
-	return s.db.Where(m).Delete(&model.Subscription{}).Error
+	ids := make([]uint, len(subs))
+	for i, sub := range subs {
+		ids[i] = sub.ID
+	}
+	// the deliveries only matter to their subscription
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id IN ?", ids).Delete(&model.Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Where(m).Delete(&model.Subscription{}).Error
+	})
+}
+
+func (s subscriptionPersistence) UpdateStatus(ids []uint, status string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.Model(&model.Subscription{}).Where("id IN ?", ids).Update("status", status).Error
 }
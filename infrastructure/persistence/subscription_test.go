@@ -1,12 +1,14 @@
 package persistence_test
 
 import (
-	"os"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
 	"github.com/google/go-cmp/cmp"
 	"gorm.io/gorm"
@@ -14,29 +16,39 @@ import (
 
 func TestSubscriptionPersistenceCreate(t *testing.T) {
 	test := []struct {
-		name string
-		args model.Subscription
-		want model.Subscription
+		name    string
+		args    model.Subscription
+		create  func(*gorm.DB)
+		want    model.Subscription
+		wantErr error
 	}{
 		{
-			name: "success",
-			args: model.Subscription{ChannelID: "1234567890", RSSURL: "https://example.com"},
-			want: model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: time.Time{}},
+			name:   "success",
+			args:   model.Subscription{ChannelID: "1234567890", FeedID: 1},
+			create: func(db *gorm.DB) {},
+			want:   model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: time.Time{}},
+		},
+		{
+			name: "duplicated",
+			args: model.Subscription{ChannelID: "1234567890", FeedID: 1},
+			create: func(db *gorm.DB) {
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1})
+			},
+			want:    model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: time.Time{}},
+			wantErr: repository.ErrAlreadyExists,
 		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			sr := persistence.NewSubscriptionPersistence(db)
 
+			// prepare
+			tt.create(db)
+
 			// test
 			err := sr.Create(tt.args)
 
@@ -48,8 +60,8 @@ func TestSubscriptionPersistenceCreate(t *testing.T) {
 			}
 
 			// assert
-			if err != nil {
-				t.Errorf("want: nil, got: %v", err)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
 			}
 			if len(got) != 1 {
 				t.Errorf("want: 1, got: %d", len(got))
@@ -62,7 +74,7 @@ func TestSubscriptionPersistenceCreate(t *testing.T) {
 }
 
 func TestSubscriptionPersistenceFindByModel(t *testing.T) {
-	now := time.Now()
+	now := databasetest.Now()
 	test := []struct {
 		name   string
 		args   model.Subscription
@@ -79,50 +91,45 @@ func TestSubscriptionPersistenceFindByModel(t *testing.T) {
 			name: "select by id",
 			args: model.Subscription{ID: 1},
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 		},
 		{
 			name: "select by ChannelID",
 			args: model.Subscription{ChannelID: "1234567890"},
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 3, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "1234567890", FeedID: 2, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 3, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
-				{ID: 2, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 2, ChannelID: "1234567890", FeedID: 2, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 		},
 		{
-			name: "select by ChannelID and RSSURL",
-			args: model.Subscription{ChannelID: "1234567890", RSSURL: "https://example.com"},
+			name: "select by ChannelID and FeedID",
+			args: model.Subscription{ChannelID: "1234567890", FeedID: 1},
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 3, ChannelID: "1234567890", RSSURL: "https://example.com/exclude", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 3, ChannelID: "0987654321", RSSURL: "https://example.com/exclude", CreatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 3, ChannelID: "1234567890", FeedID: 2, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 4, ChannelID: "0987654321", FeedID: 2, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			sr := persistence.NewSubscriptionPersistence(db)
 
@@ -144,7 +151,7 @@ func TestSubscriptionPersistenceFindByModel(t *testing.T) {
 }
 
 func TestSubscriptionPersistenceFindAll(t *testing.T) {
-	now := time.Now()
+	now := databasetest.Now()
 	test := []struct {
 		name   string
 		create func(*gorm.DB)
@@ -158,25 +165,21 @@ func TestSubscriptionPersistenceFindAll(t *testing.T) {
 		{
 			name: "multiple",
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
+				db.Create(&model.Feed{ID: 1, URL: "https://example.com", Title: "example", CreatedAt: now, UpdatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
-				{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com", Title: "example", CreatedAt: now, UpdatedAt: now}, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 2, ChannelID: "0987654321", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com", Title: "example", CreatedAt: now, UpdatedAt: now}, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			sr := persistence.NewSubscriptionPersistence(db)
 
@@ -198,7 +201,7 @@ func TestSubscriptionPersistenceFindAll(t *testing.T) {
 }
 
 func TestSubscriptionPersistenceDelete(t *testing.T) {
-	now := time.Now()
+	now := databasetest.Now()
 	test := []struct {
 		name    string
 		args    model.Subscription
@@ -210,11 +213,11 @@ func TestSubscriptionPersistenceDelete(t *testing.T) {
 			name: "success",
 			args: model.Subscription{ID: 1},
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 2, ChannelID: "0987654321", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 			withErr: false,
 		},
@@ -222,26 +225,21 @@ func TestSubscriptionPersistenceDelete(t *testing.T) {
 			name: "record not found",
 			args: model.Subscription{ID: 3},
 			create: func(db *gorm.DB) {
-				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now})
-				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now})
+				db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+				db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
 			},
 			want: []model.Subscription{
-				{ID: 1, ChannelID: "1234567890", RSSURL: "https://example.com", CreatedAt: now},
-				{ID: 2, ChannelID: "0987654321", RSSURL: "https://example.com", CreatedAt: now},
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 2, ChannelID: "0987654321", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
 			},
 			withErr: true,
 		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			sr := persistence.NewSubscriptionPersistence(db)
 
@@ -267,3 +265,59 @@ func TestSubscriptionPersistenceDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestSubscriptionPersistenceUpdateStatus(t *testing.T) {
+	now := databasetest.Now()
+	test := []struct {
+		name string
+		args []uint
+		want []model.Subscription
+	}{
+		{
+			name: "empty",
+			args: []uint{},
+			want: []model.Subscription{
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 2, ChannelID: "0987654321", FeedID: 1, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 3, ChannelID: "1234567890", FeedID: 2, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+			},
+		},
+		{
+			name: "selected",
+			args: []uint{1, 2},
+			want: []model.Subscription{
+				{ID: 1, ChannelID: "1234567890", FeedID: 1, Status: model.SubscriptionBroken, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 2, ChannelID: "0987654321", FeedID: 1, Status: model.SubscriptionBroken, OnUpdate: model.UpdateEdit, CreatedAt: now},
+				{ID: 3, ChannelID: "1234567890", FeedID: 2, Status: model.SubscriptionActive, OnUpdate: model.UpdateEdit, CreatedAt: now},
+			},
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			sr := persistence.NewSubscriptionPersistence(db)
+
+			// prepare
+			db.Create(&model.Subscription{ID: 1, ChannelID: "1234567890", FeedID: 1, CreatedAt: now})
+			db.Create(&model.Subscription{ID: 2, ChannelID: "0987654321", FeedID: 1, CreatedAt: now})
+			db.Create(&model.Subscription{ID: 3, ChannelID: "1234567890", FeedID: 2, CreatedAt: now})
+
+			// test
+			err := sr.UpdateStatus(tt.args, model.SubscriptionBroken)
+
+			got := []model.Subscription{}
+			db.Find(&got)
+
+			// assert
+			if err != nil {
+				t.Errorf("want: nil, got: %v", err)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package resolve
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+// Resolver resolves the shorthands of one scheme, given without the scheme.
+type Resolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// ResolverFunc resolves shorthands without any request.
+type ResolverFunc func(ref string) (string, error)
+
+func (f ResolverFunc) Resolve(_ context.Context, ref string) (string, error) {
+	return f(ref)
+}
+
+// Registry resolves a shorthand with the resolver registered for its scheme.
+type Registry struct {
+	resolvers map[string]Resolver
+}
+
+// NewRegistry returns a registry of the built-in shorthands, whose requests go through client with the options of defaults,
+// are cancelled after timeout and read no more than maxSize bytes.
+func NewRegistry(client *http.Client, defaults model.FetchOptions, timeout time.Duration, maxSize int) Registry {
+	r := Registry{resolvers: map[string]Resolver{}}
+	r.Register("youtube", NewYouTube(client, defaults, "https://www.youtube.com", timeout, maxSize))
+	r.Register("github", ResolverFunc(gitHub))
+	r.Register("gitlab", ResolverFunc(gitLab))
+	r.Register("reddit", ResolverFunc(reddit))
+	r.Register("mastodon", ResolverFunc(mastodon))
+	return r
+}
+
+// Register resolves the shorthands of scheme with resolver, replacing the one already registered.
+func (r Registry) Register(scheme string, resolver Resolver) {
+	r.resolvers[scheme] = resolver
+}
+
+// Resolve returns source unchanged when its scheme is not registered, such as an http URL.
+func (r Registry) Resolve(ctx context.Context, source string) (string, error) {
+	scheme, ref, ok := strings.Cut(strings.TrimSpace(source), ":")
+	if !ok {
+		return source, nil
+	}
+	resolver, ok := r.resolvers[strings.ToLower(scheme)]
+	if !ok {
+		return source, nil
+	}
+	return resolver.Resolve(ctx, strings.TrimSpace(ref))
+}
@@ -0,0 +1,150 @@
+package resolve_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/resolve"
+	"github.com/mmcdole/gofeed"
+)
+
+func TestRegistryResolve(t *testing.T) {
+	// pages recorded from youtube.com, trimmed down
+	fixtures := map[string]string{
+		"/@golang":  "testdata/youtube_channel.html",
+		"/c/golang": "testdata/youtube_consent.html",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Error(err)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	r := resolve.NewRegistry(srv.Client(), model.FetchOptions{}, 0, 0)
+	r.Register("youtube", resolve.NewYouTube(srv.Client(), model.FetchOptions{}, srv.URL, 0, 0))
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr error
+	}{
+		{name: "url", source: "https://example.com/feed", want: "https://example.com/feed"},
+		{name: "unknown scheme", source: "example:feed", want: "example:feed"},
+		{name: "youtube handle", source: "youtube:@golang", want: "https://www.youtube.com/feeds/videos.xml?channel_id=UC_BzFbxG2za3bp5NRRRXJSw"},
+		{name: "youtube custom url from scripts", source: "youtube:c/golang", want: "https://www.youtube.com/feeds/videos.xml?channel_id=UC_BzFbxG2za3bp5NRRRXJSw"},
+		{name: "youtube channel id", source: "youtube:UC_BzFbxG2za3bp5NRRRXJSw", want: "https://www.youtube.com/feeds/videos.xml?channel_id=UC_BzFbxG2za3bp5NRRRXJSw"},
+		{name: "youtube playlist", source: "youtube:playlist/PL123", want: "https://www.youtube.com/feeds/videos.xml?playlist_id=PL123"},
+		{name: "youtube unknown handle", source: "youtube:@nobody", wantErr: gofeed.HTTPError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}},
+		{name: "youtube unsupported", source: "youtube:watch", wantErr: repository.ErrUnsupportedShorthand},
+		{name: "github releases", source: "github:golang/go/releases", want: "https://github.com/golang/go/releases.atom"},
+		{name: "github repository", source: "GitHub:golang/go", want: "https://github.com/golang/go/releases.atom"},
+		{name: "github tags", source: "github:golang/go/tags", want: "https://github.com/golang/go/tags.atom"},
+		{name: "github branch commits", source: "github:golang/go/commits/release-branch.go1.22", want: "https://github.com/golang/go/commits/release-branch.go1.22.atom"},
+		{name: "github user", source: "github:golang", want: "https://github.com/golang.atom"},
+		{name: "github unsupported", source: "github:golang/go/issues", wantErr: repository.ErrUnsupportedShorthand},
+		{name: "gitlab tags", source: "gitlab:gitlab-org/gitlab/tags", want: "https://gitlab.com/gitlab-org/gitlab/-/tags?format=atom"},
+		{name: "gitlab nested releases", source: "gitlab:group/sub/project/releases", want: "https://gitlab.com/group/sub/project/-/releases.atom"},
+		{name: "gitlab commits", source: "gitlab:group/project/commits/main", want: "https://gitlab.com/group/project/-/commits/main?format=atom"},
+		{name: "gitlab project", source: "gitlab:group/project", want: "https://gitlab.com/group/project/-/releases.atom"},
+		{name: "gitlab unsupported", source: "gitlab:group", wantErr: repository.ErrUnsupportedShorthand},
+		{name: "reddit subreddit", source: "reddit:r/golang", want: "https://www.reddit.com/r/golang/.rss"},
+		{name: "reddit sorted", source: "reddit:r/golang/top", want: "https://www.reddit.com/r/golang/top/.rss"},
+		{name: "reddit user", source: "reddit:u/spez", want: "https://www.reddit.com/user/spez/.rss"},
+		{name: "reddit unsupported", source: "reddit:golang", wantErr: repository.ErrUnsupportedShorthand},
+		{name: "mastodon", source: "mastodon:@Gargron@mastodon.social", want: "https://mastodon.social/@Gargron.rss"},
+		{name: "mastodon unsupported", source: "mastodon:Gargron", wantErr: repository.ErrUnsupportedShorthand},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got, err := r.Resolve(context.Background(), tt.source)
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want: %s, got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestYouTubeResolveMaxSize(t *testing.T) {
+	// setup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/youtube_consent.html")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		maxSize int
+		want    string
+		wantErr error
+	}{
+		{name: "within the limit", maxSize: 1 << 10, want: "https://www.youtube.com/feeds/videos.xml?channel_id=UC_BzFbxG2za3bp5NRRRXJSw"},
+		// the ID is in the scripts past the bytes read
+		{name: "past the limit", maxSize: 256, wantErr: repository.ErrFeedNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare
+			y := resolve.NewYouTube(srv.Client(), model.FetchOptions{}, srv.URL, 0, tt.maxSize)
+
+			// test
+			got, err := y.Resolve(context.Background(), "c/golang")
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want: %s, got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestYouTubeResolveDefaults(t *testing.T) {
+	var got *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		http.ServeFile(w, r, "testdata/youtube_channel.html")
+	}))
+	defer proxy.Close()
+
+	// test
+	defaults := model.FetchOptions{Headers: map[string]string{"X-Api-Key": "key"}, Proxy: proxy.URL, UserAgent: "bot/1.0"}
+	y := resolve.NewYouTube(http.DefaultClient, defaults, "http://youtube.invalid", 0, 0)
+	feedURL, err := y.Resolve(context.Background(), "@golang")
+
+	// assert
+	if err != nil {
+		t.Fatalf("want: nil, got: %v", err)
+	}
+	if feedURL != "https://www.youtube.com/feeds/videos.xml?channel_id=UC_BzFbxG2za3bp5NRRRXJSw" {
+		t.Errorf("want: the feed of the channel, got: %s", feedURL)
+	}
+	// a proxy receives the absolute URL of the target
+	if got.URL.String() != "http://youtube.invalid/@golang" || got.UserAgent() != "bot/1.0" || got.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("want: the defaults sent through the proxy, got: %s %v", got.URL, got.Header)
+	}
+}
@@ -0,0 +1,88 @@
+package resolve
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+)
+
+// segments splits ref into its non-empty path segments.
+func segments(ref string) []string {
+	return strings.FieldsFunc(ref, func(r rune) bool { return r == '/' })
+}
+
+func unsupported(scheme, ref string) error {
+	return fmt.Errorf("%w: %s:%s", repository.ErrUnsupportedShorthand, scheme, ref)
+}
+
+// gitHub resolves owner, owner/repo, and owner/repo/{releases,tags,commits[/branch]}.
+// A repository alone means its releases.
+func gitHub(ref string) (string, error) {
+	s := segments(ref)
+	switch {
+	case len(s) == 1:
+		return "https://github.com/" + url.PathEscape(s[0]) + ".atom", nil
+	case len(s) < 2:
+		return "", unsupported("github", ref)
+	}
+	repo := "https://github.com/" + url.PathEscape(s[0]) + "/" + url.PathEscape(s[1])
+	switch {
+	case len(s) == 2, len(s) == 3 && s[2] == "releases":
+		return repo + "/releases.atom", nil
+	case len(s) == 3 && s[2] == "tags":
+		return repo + "/tags.atom", nil
+	case len(s) == 3 && s[2] == "commits":
+		return repo + "/commits.atom", nil
+	case len(s) >= 4 && s[2] == "commits":
+		return repo + "/commits/" + strings.Join(s[3:], "/") + ".atom", nil
+	}
+	return "", unsupported("github", ref)
+}
+
+// gitLab resolves group/project and group/project/{releases,tags,commits[/branch]} on gitlab.com,
+// where the project may be in nested groups. A project alone means its releases.
+func gitLab(ref string) (string, error) {
+	s := segments(ref)
+	for i := 2; i < len(s); i++ {
+		project := "https://gitlab.com/" + strings.Join(s[:i], "/") + "/-/"
+		switch {
+		case i == len(s)-1 && s[i] == "releases":
+			return project + "releases.atom", nil
+		case i == len(s)-1 && s[i] == "tags":
+			return project + "tags?format=atom", nil
+		case s[i] == "commits":
+			branch := strings.Join(s[i+1:], "/")
+			if branch == "" {
+				branch = "HEAD"
+			}
+			return project + "commits/" + branch + "?format=atom", nil
+		}
+	}
+	if len(s) >= 2 {
+		return "https://gitlab.com/" + strings.Join(s, "/") + "/-/releases.atom", nil
+	}
+	return "", unsupported("gitlab", ref)
+}
+
+// reddit resolves r/subreddit, optionally followed by a sort such as top, and u/user.
+func reddit(ref string) (string, error) {
+	s := segments(ref)
+	switch {
+	case len(s) == 2 && s[0] == "r", len(s) == 3 && s[0] == "r":
+		return "https://www.reddit.com/r/" + strings.Join(s[1:], "/") + "/.rss", nil
+	case len(s) == 2 && (s[0] == "u" || s[0] == "user"):
+		return "https://www.reddit.com/user/" + s[1] + "/.rss", nil
+	}
+	return "", unsupported("reddit", ref)
+}
+
+// mastodon resolves @user@instance into the public posts of the account.
+func mastodon(ref string) (string, error) {
+	user, instance, ok := strings.Cut(strings.TrimPrefix(ref, "@"), "@")
+	if !ok || user == "" || instance == "" || strings.ContainsAny(user+instance, "/?#") {
+		return "", unsupported("mastodon", ref)
+	}
+	return "https://" + instance + "/@" + user + ".rss", nil
+}
@@ -0,0 +1,114 @@
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/fetch"
+	"github.com/mmcdole/gofeed"
+)
+
+const youTubeFeeds = "https://www.youtube.com/feeds/videos.xml"
+
+// channelID matches the ID of a channel embedded in the scripts of its page
+var channelID = regexp.MustCompile(`"(?:externalId|channelId)":"(UC[\w-]{22})"`)
+
+type YouTube struct {
+	// client is the client given sending its requests through the proxy of defaults
+	client   func() (*http.Client, error)
+	defaults model.FetchOptions
+	base     string
+	timeout  time.Duration
+	maxSize  int
+}
+
+// NewYouTube returns a resolver looking the channels up at base through client with the options of defaults,
+// whose requests are cancelled after timeout and of whose pages no more than maxSize bytes are read, unbounded when zero.
+func NewYouTube(client *http.Client, defaults model.FetchOptions, base string, timeout time.Duration, maxSize int) YouTube {
+	proxied := sync.OnceValues(func() (*http.Client, error) {
+		return fetch.Proxied(client, defaults.Proxy)
+	})
+	return YouTube{client: proxied, defaults: defaults, base: strings.TrimSuffix(base, "/"), timeout: timeout, maxSize: maxSize}
+}
+
+// Resolve resolves a channel ID, channel/ID, playlist/ID and user/name without any request,
+// and @handle or c/name by looking the channel ID up on its page.
+func (y YouTube) Resolve(ctx context.Context, ref string) (string, error) {
+	s := segments(ref)
+	switch {
+	case len(s) == 1 && strings.HasPrefix(s[0], "UC"):
+		return youTubeFeeds + "?channel_id=" + url.QueryEscape(s[0]), nil
+	case len(s) == 2 && s[0] == "channel":
+		return youTubeFeeds + "?channel_id=" + url.QueryEscape(s[1]), nil
+	case len(s) == 2 && s[0] == "playlist":
+		return youTubeFeeds + "?playlist_id=" + url.QueryEscape(s[1]), nil
+	case len(s) == 2 && s[0] == "user":
+		return youTubeFeeds + "?user=" + url.QueryEscape(s[1]), nil
+	case len(s) == 1 && strings.HasPrefix(s[0], "@"), len(s) == 2 && s[0] == "c":
+		id, err := y.lookup(ctx, y.base+"/"+strings.Join(s, "/"))
+		if err != nil {
+			return "", err
+		}
+		return youTubeFeeds + "?channel_id=" + url.QueryEscape(id), nil
+	}
+	return "", unsupported("youtube", ref)
+}
+
+// lookup returns the channel ID of the channel page at pageURL,
+// preferring the feed it advertises over the ID found in its scripts.
+func (y YouTube) lookup(ctx context.Context, pageURL string) (string, error) {
+	if y.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, y.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	fetch.SetOptions(req, y.defaults)
+	client, err := y.client()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	var r io.Reader = resp.Body
+	if y.maxSize > 0 {
+		r = io.LimitReader(r, int64(y.maxSize))
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if href, ok := doc.Find(`link[rel="alternate"][type="application/rss+xml"]`).Attr("href"); ok {
+		if u, err := url.Parse(href); err == nil && u.Query().Get("channel_id") != "" {
+			return u.Query().Get("channel_id"), nil
+		}
+	}
+	if m := channelID.FindSubmatch(body); m != nil {
+		return string(m[1]), nil
+	}
+	return "", repository.ErrFeedNotFound
+}
@@ -1,39 +1,70 @@
 package discord
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
 	"github.com/olekukonko/tablewriter"
+	"golang.org/x/net/html"
 )
 
 type rssEntriesUsecase interface {
-	Check(s model.Subscription) model.RssEntry
-	CheckNewEntries(s []model.Subscription) []model.RssEntry
+	Check(ctx context.Context, s model.Subscription) model.RssEntry
+	CheckNewEntries(ctx context.Context, s []model.Subscription) ([]model.RssEntry, []model.Notice)
+	Prune()
 }
 
 type subscriptionUsecase interface {
+	Resolve(ctx context.Context, source string) (string, error)
+	Discover(ctx context.Context, url string) ([]repository.DiscoveredFeed, error)
 	FindAll() ([]model.Subscription, error)
-	Create(sub model.Subscription) string
+	Create(ctx context.Context, sub model.Subscription) string
 	Delete(sub model.Subscription) error
 	List(sub model.Subscription) ([]model.Subscription, error)
 }
 
+type deliveryUsecase interface {
+	Sent(delivery model.Delivery, messageID string) error
+	Failed(delivery model.Delivery, err error) error
+	Due(s []model.Subscription) ([]model.Delivery, error)
+}
+
+type webSubUsecase interface {
+	Renew(ctx context.Context, s []model.Subscription)
+}
+
+// SubscribeSelectID is the custom ID of the feed select menu sent by Create.
+// The requested interval, if any, follows it after a colon.
+const SubscribeSelectID = "subscribe_select"
+
 type DiscordHandler struct {
 	ds *discordgo.Session
 	su subscriptionUsecase
 	ru rssEntriesUsecase
+	wu webSubUsecase
+	du deliveryUsecase
+	// wake tells the dispatcher that deliveries were queued
+	wake          chan struct{}
+	pollTick      time.Duration
+	pollTimeout   time.Duration
+	pruneInterval time.Duration
 }
 
-func NewDiscordHandler(ds *discordgo.Session, su subscriptionUsecase, ru rssEntriesUsecase) DiscordHandler {
-	return DiscordHandler{ds: ds, su: su, ru: ru}
+func NewDiscordHandler(ds *discordgo.Session, su subscriptionUsecase, ru rssEntriesUsecase, wu webSubUsecase, du deliveryUsecase, pollTick, pollTimeout, pruneInterval time.Duration) DiscordHandler {
+	return DiscordHandler{ds: ds, su: su, ru: ru, wu: wu, du: du, wake: make(chan struct{}, 1), pollTick: pollTick, pollTimeout: pollTimeout, pruneInterval: pruneInterval}
 }
 
 func (d DiscordHandler) Create(ds *discordgo.Session, dic *discordgo.InteractionCreate) {
@@ -45,26 +76,216 @@ func (d DiscordHandler) Create(ds *discordgo.Session, dic *discordgo.Interaction
 	}
 	value := optionMap["url"].StringValue()
 
-	// validate URL
-	validUrl, err := url.ParseRequestURI(value)
+	// validate interval
+	interval, err := parseInterval(optionMap)
 	if err != nil {
 		_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "Invalid URL.",
+				Content: "Invalid interval. Use a duration such as 30m or 1h.",
+			},
+		})
+		return
+	}
+	onUpdate := parseOnUpdate(optionMap)
+	fetchOptions, err := parseFetchOptions(optionMap)
+	if err != nil {
+		_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Invalid headers. Use Name: value; Name: value.",
+				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
 		return
 	}
+
+	// resolving a shorthand and discovery may fetch several URLs, which takes longer than an interaction may wait.
+	// Options may hold credentials, so the channel does not get to see them.
+	response := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}
+	if !fetchOptions.IsZero() || strings.Contains(value, "@") {
+		response.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+	_ = ds.InteractionRespond(dic.Interaction, response)
+	resolved, err := d.su.Resolve(context.Background(), value)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to resolve %s: %v", value, err))
+		content := fmt.Sprintf("Failed to resolve: %s", value)
+		if errors.Is(err, repository.ErrUnsupportedShorthand) {
+			content = fmt.Sprintf("Unsupported shorthand: %s", value)
+		}
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+
+	// validate URL
+	validUrl, err := url.ParseRequestURI(resolved)
+	if err != nil {
+		content := "Invalid URL."
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
 	rssUrl := validUrl.String()
-	// subscribe
-	d.su.Create(model.Subscription{ChannelID: dic.ChannelID, RSSURL: rssUrl})
+	// the discoverer does not send the options, so a URL needing them or credentials must be the feed itself
+	if !fetchOptions.IsZero() || validUrl.User != nil {
+		content := d.subscribe(dic.ChannelID, rssUrl, fetchOptions, interval, onUpdate)
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+	feeds, err := d.su.Discover(context.Background(), rssUrl)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to discover feeds at %s: %v", validUrl.Redacted(), err))
+		content := fmt.Sprintf("No RSS feed found at: %s", validUrl.Redacted())
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+	if len(feeds) == 1 {
+		content := d.subscribe(dic.ChannelID, feeds[0].URL, model.FetchOptions{}, interval, onUpdate)
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+
+	// let the user pick one of the advertised feeds
+	menu := make([]discordgo.SelectMenuOption, 0, len(feeds))
+	for _, feed := range feeds {
+		// Discord limits option values to 100 characters and menus to 25 options
+		if len(feed.URL) > 100 || len(menu) == 25 {
+			continue
+		}
+		label := feed.Title
+		if label == "" {
+			label = feed.URL
+		}
+		menu = append(menu, discordgo.SelectMenuOption{Label: truncate(label, 100), Value: feed.URL, Description: truncate(feed.URL, 100)})
+	}
+	if len(menu) == 0 {
+		content := d.subscribe(dic.ChannelID, feeds[0].URL, model.FetchOptions{}, interval, onUpdate)
+		_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+	content := fmt.Sprintf("Multiple RSS feeds found at: %s", validUrl.Redacted())
+	customID := SubscribeSelectID
+	if interval > 0 || onUpdate != "" {
+		customID += ":"
+		if interval > 0 {
+			customID += interval.String()
+		}
+		if onUpdate != "" {
+			customID += ":" + onUpdate
+		}
+	}
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{CustomID: customID, Placeholder: "Select a feed to subscribe", Options: menu},
+		}},
+	}
+	_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content, Components: &components})
+}
+
+// SubscribeSelect subscribes to the feed picked from the menu sent by Create.
+func (d DiscordHandler) SubscribeSelect(ds *discordgo.Session, dic *discordgo.InteractionCreate) {
+	data := dic.MessageComponentData()
+	values := data.Values
+	if len(values) == 0 {
+		return
+	}
+	var interval time.Duration
+	var onUpdate string
+	if _, v, ok := strings.Cut(data.CustomID, ":"); ok {
+		v, onUpdate, _ = strings.Cut(v, ":")
+		interval, _ = time.ParseDuration(v)
+	}
+	// the feed is fetched before subscribing, so acknowledge first
 	_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("Successfully subscribed to RSS feed: %s", rssUrl),
-		},
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
 	})
+	content := d.subscribe(dic.ChannelID, values[0], model.FetchOptions{}, interval, onUpdate)
+	components := []discordgo.MessageComponent{}
+	_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content, Components: &components})
+}
+
+func (d DiscordHandler) subscribe(channelID, rssUrl string, options model.FetchOptions, interval time.Duration, onUpdate string) string {
+	return d.su.Create(context.Background(), model.Subscription{ChannelID: channelID, Feed: model.Feed{URL: rssUrl, Options: options}, Interval: interval, OnUpdate: onUpdate})
+}
+
+// parseOnUpdate returns the on_update option, empty for the default when it is omitted.
+func parseOnUpdate(optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) string {
+	if option, ok := optionMap["on_update"]; ok {
+		return option.StringValue()
+	}
+	return ""
+}
+
+// parseFetchOptions returns the options customizing the requests of a feed, zero when none is given.
+func parseFetchOptions(optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) (model.FetchOptions, error) {
+	str := func(name string) string {
+		if option, ok := optionMap[name]; ok {
+			return option.StringValue()
+		}
+		return ""
+	}
+	options := model.FetchOptions{
+		Username:  str("username"),
+		Password:  str("password"),
+		Token:     str("token"),
+		Proxy:     str("proxy"),
+		UserAgent: str("user_agent"),
+	}
+	if h := str("headers"); h != "" {
+		headers, err := model.ParseHeaders(h)
+		if err != nil {
+			return model.FetchOptions{}, err
+		}
+		options.Headers = headers
+	}
+	return options, nil
+}
+
+// Scrape subscribes to a page without a feed, whose items are built from CSS selectors.
+func (d DiscordHandler) Scrape(ds *discordgo.Session, dic *discordgo.InteractionCreate) {
+	// get options
+	options := dic.ApplicationCommandData().Options
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, option := range options {
+		optionMap[option.Name] = option
+	}
+	interval, err := parseInterval(optionMap)
+	if err != nil {
+		_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Invalid interval. Use a duration such as 30m or 1h.",
+			},
+		})
+		return
+	}
+	selector := model.Selector{Item: optionMap["item"].StringValue()}
+	if option, ok := optionMap["title"]; ok {
+		selector.Title = option.StringValue()
+	}
+	if option, ok := optionMap["link"]; ok {
+		selector.Link = option.StringValue()
+	}
+	if option, ok := optionMap["date"]; ok {
+		selector.Date = option.StringValue()
+	}
+
+	// the page is fetched before subscribing, so acknowledge first
+	_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	sub := model.Subscription{ChannelID: dic.ChannelID, Feed: model.Feed{URL: optionMap["url"].StringValue(), Selector: selector}, Interval: interval, OnUpdate: parseOnUpdate(optionMap)}
+	content := d.su.Create(context.Background(), sub)
+	_, _ = ds.InteractionResponseEdit(dic.Interaction, &discordgo.WebhookEdit{Content: &content})
+}
+
+// parseInterval returns the interval option, zero when it is omitted.
+func parseInterval(optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) (time.Duration, error) {
+	option, ok := optionMap["interval"]
+	if !ok {
+		return 0, nil
+	}
+	return time.ParseDuration(option.StringValue())
 }
 
 func (d DiscordHandler) List(ds *discordgo.Session, dic *discordgo.InteractionCreate) {
@@ -75,9 +296,25 @@ func (d DiscordHandler) List(ds *discordgo.Session, dic *discordgo.InteractionCr
 
 	tableString := &strings.Builder{}
 	table := tablewriter.NewWriter(tableString)
-	table.Header([]string{"ID", "RSS URL"})
+	table.Header([]string{"ID", "RSS URL", "Interval", "Status"})
 	for _, value := range values {
-		if err := table.Append([]string{strconv.Itoa(int(value.ID)), value.RSSURL}); err != nil {
+		interval := "default"
+		if value.Interval > 0 {
+			interval = value.Interval.String()
+		}
+		// credentials are never shown, only whether the feed has some
+		source := redact(value.Feed.URL)
+		if value.Feed.Scraped() {
+			source += " (" + value.Feed.Selector.Item + ")"
+		}
+		if o := value.Feed.Options; o.Username != "" || o.Password != "" || o.Token != "" {
+			source += " (auth)"
+		}
+		status := value.Status
+		if len(value.Feed.Repairs) > 0 {
+			status += " (repaired)"
+		}
+		if err := table.Append([]string{strconv.Itoa(int(value.ID)), source, interval, status}); err != nil {
 			slog.Error(fmt.Sprintf("Failed to append to table: %v", err))
 		}
 	}
@@ -145,7 +382,7 @@ func (d DiscordHandler) Check(ds *discordgo.Session, dic *discordgo.InteractionC
 		return
 	}
 	rssUrl := validUrl.String()
-	rss := d.ru.Check(model.Subscription{RSSURL: rssUrl})
+	rss := d.ru.Check(context.Background(), model.Subscription{Feed: model.Feed{URL: rssUrl}})
 	if rss.EntryTitle == "" {
 		_ = ds.InteractionRespond(dic.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -155,14 +392,7 @@ func (d DiscordHandler) Check(ds *discordgo.Session, dic *discordgo.InteractionC
 		})
 		return
 	}
-	msg := &discordgo.MessageSend{
-		Embed: &discordgo.MessageEmbed{
-			Title:       rss.EntryTitle,
-			URL:         rss.EntryLink,
-			Description: rss.EntryTitle,
-			Timestamp:   rss.PublishedAt.Format("2006-01-02 15:04:05"),
-		},
-	}
+	msg := &discordgo.MessageSend{Embed: embedOf(rss, model.Feed{})}
 	if _, err := d.ds.ChannelMessageSendComplex(dic.ChannelID, msg); err != nil {
 		slog.Error(fmt.Sprintf("Failed to send message: %v", err))
 	}
@@ -174,38 +404,254 @@ func (d DiscordHandler) Check(ds *discordgo.Session, dic *discordgo.InteractionC
 	})
 }
 
+// CheckNewEntries polls once right away, so feeds overdue across a restart catch up immediately,
+// then on every tick. Each poll only fetches the feeds that are due.
 func (d DiscordHandler) CheckNewEntries(ctx context.Context) {
-	t := time.NewTicker(10 * time.Minute)
+	t := time.NewTicker(d.pollTick)
 	defer t.Stop()
 
 	for {
+		d.poll(ctx)
 		select {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			subs, err := d.su.FindAll()
-			if err != nil {
-				slog.Warn(fmt.Sprintf("error fetching subscriptions: %v", err))
-				return
-			}
-			newEntries := d.ru.CheckNewEntries(subs)
-			for _, entry := range subs {
-				for _, newEntry := range newEntries {
-					if entry.RSSURL == newEntry.RSSURL {
-						msg := &discordgo.MessageSend{
-							Embed: &discordgo.MessageEmbed{
-								Title:       newEntry.EntryTitle,
-								URL:         newEntry.EntryLink,
-								Description: newEntry.EntryTitle,
-								Timestamp:   newEntry.PublishedAt.Format("2006-01-02 15:04:05"),
-							},
-						}
-						if _, err := d.ds.ChannelMessageSendComplex(entry.ChannelID, msg); err != nil {
-							slog.Error(fmt.Sprintf("Failed to send message: %v", err))
-						}
-					}
+		}
+	}
+}
+
+func (d DiscordHandler) poll(ctx context.Context) {
+	subs, err := d.su.FindAll()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("error fetching subscriptions: %v", err))
+		return
+	}
+	d.wu.Renew(ctx, subs)
+	_, notices := d.checkNewEntries(ctx, subs)
+	d.Deliver(notices)
+}
+
+// Deliver sends the notices and wakes the dispatcher up for the entries just queued,
+// whether found by a poll or pushed by a WebSub hub.
+func (d DiscordHandler) Deliver(notices []model.Notice) {
+	for _, notice := range notices {
+		if _, err := d.ds.ChannelMessageSend(notice.ChannelID, notice.Content); err != nil {
+			slog.Error(fmt.Sprintf("Failed to send notice: %v", err))
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch drains the queued deliveries into Discord when woken up by Deliver and on every tick,
+// which also sends the ones left by a restart and retries the failed ones once due.
+func (d DiscordHandler) Dispatch(ctx context.Context) {
+	t := time.NewTicker(d.pollTick)
+	defer t.Stop()
+
+	for {
+		d.dispatch()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d DiscordHandler) dispatch() {
+	subs, err := d.su.FindAll()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("error fetching subscriptions: %v", err))
+		return
+	}
+	deliveries, err := d.du.Due(subs)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("error fetching deliveries: %v", err))
+		return
+	}
+	for _, delivery := range deliveries {
+		if delivery.Action == model.DeliveryEdit {
+			d.edit(delivery)
+			continue
+		}
+		d.post(delivery)
+	}
+}
+
+// post sends the entry of delivery as a new message and records the outcome.
+func (d DiscordHandler) post(delivery model.Delivery) {
+	msg, err := d.send(delivery.Subscription.ChannelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embedOf(delivery.Entry, delivery.Subscription.Feed)}}, delivery.Nonce)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to send message: %v", err))
+		d.failed(delivery, err)
+		return
+	}
+	if err := d.du.Sent(delivery, msg.ID); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to record the message of %s: %v", delivery.Entry.EntryLink, err))
+	}
+}
+
+// edit updates the message the entry of delivery was posted as.
+// An entry posted before messages were recorded has no message to edit, so it is left as it is.
+func (d DiscordHandler) edit(delivery model.Delivery) {
+	if delivery.MessageID != "" {
+		edit := discordgo.NewMessageEdit(delivery.Subscription.ChannelID, delivery.MessageID)
+		edit.Embeds = &[]*discordgo.MessageEmbed{embedOf(delivery.Entry, delivery.Subscription.Feed)}
+		if _, err := d.ds.ChannelMessageEditComplex(edit); err != nil {
+			slog.Error(fmt.Sprintf("Failed to edit message: %v", err))
+			d.failed(delivery, err)
+			return
+		}
+	}
+	if err := d.du.Sent(delivery, delivery.MessageID); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to record the edit of %s: %v", delivery.Entry.EntryLink, err))
+	}
+}
+
+func (d DiscordHandler) failed(delivery model.Delivery, err error) {
+	if err := d.du.Failed(delivery, err); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to record the failed delivery of %s: %v", delivery.Entry.EntryLink, err))
+	}
+}
+
+// messageSend adds the nonce discordgo does not send. With enforce_nonce,
+// Discord returns the message already created with the same nonce instead of creating another.
+type messageSend struct {
+	*discordgo.MessageSend
+	Nonce        string `json:"nonce,omitempty"`
+	EnforceNonce bool   `json:"enforce_nonce,omitempty"`
+}
+
+// send posts msg to channelID with nonce, so a retry of a post whose response was lost does not post it twice.
+// Discord only remembers nonces for a few minutes, later retries may post the message again.
+func (d DiscordHandler) send(channelID string, msg *discordgo.MessageSend, nonce string) (*discordgo.Message, error) {
+	for _, embed := range msg.Embeds {
+		if embed.Type == "" {
+			embed.Type = discordgo.EmbedTypeRich
+		}
+	}
+	endpoint := discordgo.EndpointChannelMessages(channelID)
+	body, err := d.ds.RequestWithBucketID(http.MethodPost, endpoint, messageSend{MessageSend: msg, Nonce: nonce, EnforceNonce: nonce != ""}, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var m discordgo.Message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// embedOf builds the message of an entry: its summary, else its content, as text, its authors and its image,
+// with the title and the icon of its feed in the footer.
+func embedOf(entry model.RssEntry, feed model.Feed) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     truncate(entry.EntryTitle, 256),
+		URL:       entry.EntryLink,
+		Timestamp: entry.PublishedAt.Format("2006-01-02 15:04:05"),
+	}
+	var names []string
+	for _, a := range entry.Authors {
+		names = append(names, cmp.Or(a.Name, a.Email))
+	}
+	if len(names) > 0 {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: truncate(strings.Join(names, ", "), 256)}
+	}
+	if entry.ImageURL != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: entry.ImageURL}
+	}
+	if feed.Title != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: truncate(feed.Title, 2048), IconURL: feed.IconURL}
+	}
+	// the description takes what the other texts leave of the length Discord accepts for a whole embed
+	left := embedMaxLength - utf8.RuneCountInString(embed.Title)
+	if embed.Author != nil {
+		left -= utf8.RuneCountInString(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		left -= utf8.RuneCountInString(embed.Footer.Text)
+	}
+	embed.Description = truncate(htmlText(cmp.Or(entry.Description, entry.Content)), min(4096, left))
+	return embed
+}
+
+// embedMaxLength is how many characters Discord accepts across the title, description, author and footer of an embed
+const embedMaxLength = 6000
+
+// htmlText returns the text of an HTML fragment, breaking the lines at its blocks and collapsing the spaces.
+// Scripts and styles are left out.
+func htmlText(s string) string {
+	var b strings.Builder
+	skip := false
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			lines := strings.Split(b.String(), "\n")
+			res := make([]string, 0, len(lines))
+			for _, l := range lines {
+				if l = strings.Join(strings.Fields(l), " "); l != "" {
+					res = append(res, l)
 				}
 			}
+			return strings.Join(res, "\n")
+		case html.TextToken:
+			if skip {
+				continue
+			}
+			b.WriteString(strings.ReplaceAll(string(z.Text()), "\n", " "))
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				skip = tt == html.StartTagToken
+			case "br", "p", "div", "li", "tr", "blockquote", "pre", "h1", "h2", "h3", "h4", "h5", "h6":
+				b.WriteByte('\n')
+			}
 		}
 	}
 }
+
+// PruneEntries deletes the entries past the retention on every prune interval.
+func (d DiscordHandler) PruneEntries(ctx context.Context) {
+	t := time.NewTicker(d.pruneInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.ru.Prune()
+		}
+	}
+}
+
+// checkNewEntries bounds a whole polling cycle, so a hung cycle never overlaps the next tick
+func (d DiscordHandler) checkNewEntries(ctx context.Context, subs []model.Subscription) ([]model.RssEntry, []model.Notice) {
+	ctx, cancel := context.WithTimeout(ctx, d.pollTimeout)
+	defer cancel()
+	return d.ru.CheckNewEntries(ctx, subs)
+}
+
+// redact hides the password of a URL.
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,57 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+func TestEmbedOf(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("あ", n) }
+	tests := []struct {
+		name            string
+		entry           model.RssEntry
+		feed            model.Feed
+		wantDescription int
+	}{
+		{
+			name:            "description only",
+			entry:           model.RssEntry{Description: long(5000)},
+			wantDescription: 4096,
+		},
+		{
+			name: "every text at its maximum",
+			entry: model.RssEntry{
+				EntryTitle:  long(300),
+				Description: long(5000),
+				Authors:     []model.Author{{Name: long(200)}, {Name: long(200)}},
+			},
+			feed:            model.Feed{Title: long(3000)},
+			wantDescription: 6000 - 256 - 256 - 2048,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got := embedOf(tt.entry, tt.feed)
+
+			// assert
+			total := utf8.RuneCountInString(got.Title) + utf8.RuneCountInString(got.Description)
+			if got.Author != nil {
+				total += utf8.RuneCountInString(got.Author.Name)
+			}
+			if got.Footer != nil {
+				total += utf8.RuneCountInString(got.Footer.Text)
+			}
+			if total > 6000 {
+				t.Errorf("want: at most 6000 characters, got: %d", total)
+			}
+			if n := utf8.RuneCountInString(got.Description); n != tt.wantDescription {
+				t.Errorf("want: a description of %d characters, got: %d", tt.wantDescription, n)
+			}
+		})
+	}
+}
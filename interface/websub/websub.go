@@ -0,0 +1,91 @@
+package websub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+)
+
+// maxBody caps the size of pushed content
+const maxBody = 10 << 20
+
+type webSubUsecase interface {
+	Verify(feedID uint, mode, topic string, lease time.Duration) error
+	Receive(ctx context.Context, feedID uint, body []byte, signature string) ([]model.RssEntry, []model.Notice, error)
+}
+
+type deliverer interface {
+	Deliver(notices []model.Notice)
+}
+
+type WebSubHandler struct {
+	wu webSubUsecase
+	d  deliverer
+}
+
+func NewWebSubHandler(wu webSubUsecase, d deliverer) WebSubHandler {
+	return WebSubHandler{wu: wu, d: d}
+}
+
+// Verify answers the intent verification of a hub by echoing its challenge.
+func (h WebSubHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	mode := q.Get("hub.mode")
+	lease, _ := strconv.Atoi(q.Get("hub.lease_seconds"))
+	err = h.wu.Verify(uint(id), mode, q.Get("hub.topic"), time.Duration(lease)*time.Second)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to verify the WebSub subscription of feed %d: %v", id, err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if mode == "denied" {
+		return
+	}
+	_, _ = w.Write([]byte(q.Get("hub.challenge")))
+}
+
+// Receive accepts the content pushed by a hub, whose new entries are queued for delivery.
+func (h WebSubHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		// tell the hub to drop the subscription
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	_, notices, err := h.wu.Receive(r.Context(), uint(id), body, r.Header.Get("X-Hub-Signature"))
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		w.WriteHeader(http.StatusGone)
+		return
+	case errors.Is(err, repository.ErrInvalidSignature):
+		// the content is ignored, but the hub must not learn whether the signature matched
+		slog.Warn(fmt.Sprintf("ignored WebSub content of feed %d: %v", id, err))
+	case err != nil:
+		slog.Warn(fmt.Sprintf("failed to process WebSub content of feed %d: %v", id, err))
+	default:
+		h.d.Deliver(notices)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"text/tabwriter"
+	"time"
 
+	"github.com/dev-shimada/discord-rss-bot/config"
 	"github.com/dev-shimada/discord-rss-bot/di"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
 	"github.com/dev-shimada/discord-rss-bot/router"
@@ -14,11 +17,27 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+
 	// Discord Bot Token
 	token := os.Getenv("DISCORD_BOT_TOKEN")
+	cfg := config.Load()
 
+	if cfg.EncryptionKey != nil {
+		if err := database.SetEncryptionKey(cfg.EncryptionKey); err != nil {
+			slog.Error(fmt.Sprintf("invalid encryption key: %v", err))
+		}
+	}
 	db := database.NewDB()
 	defer database.CloseDB(db)
+	// a feed whose options cannot be opened would fail every query loading the feeds
+	if err := database.CheckSealed(db); err != nil {
+		slog.Error(fmt.Sprintf("set the ENCRYPTION_KEY the options of the feeds were sealed with: %v", err))
+		database.CloseDB(db)
+		os.Exit(1)
+	}
 
 	// Create a new Discord session using the provided bot token.
 	session, err := router.NewRouter(token)
@@ -27,8 +46,48 @@ func main() {
 	}
 
 	// DI
-	dh := di.DiscordHandler(db, session)
+	dh, wh := di.DiscordHandler(db, session, cfg)
 
 	// Open Discord session
-	router.Open(session, dh)
+	router.Open(session, dh, router.WebSub{Addr: cfg.WebSubListenAddr, CallbackURL: cfg.WebSubCallbackURL, Handler: wh})
+}
+
+// migrate runs the migrate command and returns its exit code:
+// status lists the migrations of the database, up applies the pending ones and lists them afterwards.
+func migrate(args []string) int {
+	if len(args) != 1 || args[0] != "status" && args[0] != "up" {
+		fmt.Fprintln(os.Stderr, "usage: discord-rss-bot migrate status|up")
+		return 2
+	}
+	db := database.OpenDB()
+	if db == nil {
+		return 1
+	}
+	defer database.CloseDB(db)
+
+	if args[0] == "up" {
+		if err := database.Migrate(db); err != nil {
+			slog.Error(fmt.Sprint(err))
+			return 1
+		}
+	}
+	status, err := database.Status(db)
+	if err != nil {
+		slog.Error(fmt.Sprint(err))
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		applied := "pending"
+		if !s.Pending() {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error(fmt.Sprint(err))
+		return 1
+	}
+	return 0
 }
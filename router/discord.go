@@ -6,17 +6,23 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/dev-shimada/discord-rss-bot/interface/discord"
 )
 
 type discordHandler interface {
 	Create(ds *discordgo.Session, dig *discordgo.InteractionCreate)
+	Scrape(ds *discordgo.Session, dig *discordgo.InteractionCreate)
+	SubscribeSelect(ds *discordgo.Session, dig *discordgo.InteractionCreate)
 	List(ds *discordgo.Session, dig *discordgo.InteractionCreate)
 	Delete(ds *discordgo.Session, dig *discordgo.InteractionCreate)
 	Check(ds *discordgo.Session, dig *discordgo.InteractionCreate)
 	CheckNewEntries(ctx context.Context)
+	Dispatch(ctx context.Context)
+	PruneEntries(ctx context.Context)
 }
 
 func NewRouter(token string) (*discordgo.Session, error) {
@@ -27,7 +33,7 @@ func NewRouter(token string) (*discordgo.Session, error) {
 	return dg, nil
 }
 
-func Open(dg *discordgo.Session, dh discordHandler) {
+func Open(dg *discordgo.Session, dh discordHandler, ws WebSub) {
 	err := dg.Open()
 	if err != nil {
 		slog.Error(fmt.Sprintf("error opening connection: %v", err))
@@ -46,9 +52,62 @@ func Open(dg *discordgo.Session, dh discordHandler) {
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
 					Name:        "url",
-					Description: "https://example.com/index.xml",
+					Description: "https://example.com/index.xml or https://example.com/",
 					Required:    true,
 				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "interval",
+					Description: "How often to poll the feed, e.g. 30m or 1h. Learned from the feed when omitted",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "headers",
+					Description: "Request headers, e.g. X-Api-Key: value; Accept-Language: ja",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "username",
+					Description: "Basic auth username",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "password",
+					Description: "Basic auth password",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "token",
+					Description: "Bearer token",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "proxy",
+					Description: "http, https or socks5 proxy URL, e.g. socks5://proxy:1080",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "user_agent",
+					Description: "User-Agent header sent with the requests",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "on_update",
+					Description: "What to do when a posted entry changes. Edits its message when omitted",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "edit", Value: "edit"},
+						{Name: "repost", Value: "repost"},
+						{Name: "ignore", Value: "ignore"},
+					},
+				},
 			},
 		},
 	)
@@ -56,6 +115,68 @@ func Open(dg *discordgo.Session, dh discordHandler) {
 		slog.Error(fmt.Sprintf("error creating 'subscribe' command: %v", err))
 		return
 	}
+	// add scrape command
+	_, err = dg.ApplicationCommandCreate(
+		dg.State.User.ID,
+		dg.State.Application.GuildID,
+		&discordgo.ApplicationCommand{
+			Name:        "scrape",
+			Description: "Subscribe to a web page without a feed, using CSS selectors",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "url",
+					Description: "https://example.com/news",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "item",
+					Description: "Selector of each entry, e.g. article",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "title",
+					Description: "Selector of the title within an entry. The text of the entry when omitted",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "link",
+					Description: "Selector of the link within an entry. Its first link when omitted",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "date",
+					Description: "Selector of the date within an entry. Dated when first seen when omitted",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "interval",
+					Description: "How often to poll the page, e.g. 30m or 1h. Learned from the page when omitted",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "on_update",
+					Description: "What to do when a posted entry changes. Edits its message when omitted",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "edit", Value: "edit"},
+						{Name: "repost", Value: "repost"},
+						{Name: "ignore", Value: "ignore"},
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		slog.Error(fmt.Sprintf("error creating 'scrape' command: %v", err))
+		return
+	}
 	// add list command
 	_, err = dg.ApplicationCommandCreate(
 		dg.State.User.ID,
@@ -115,14 +236,27 @@ func Open(dg *discordgo.Session, dh discordHandler) {
 	// add handler
 	commandHandlers := map[string]func(*discordgo.Session, *discordgo.InteractionCreate){
 		"subscribe":   dh.Create,
+		"scrape":      dh.Scrape,
 		"list":        dh.List,
 		"unsubscribe": dh.Delete,
 		"check":       dh.Check,
 	}
+	componentHandlers := map[string]func(*discordgo.Session, *discordgo.InteractionCreate){
+		discord.SubscribeSelectID: dh.SubscribeSelect,
+	}
 	dg.AddHandler(
 		func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-				h(s, i)
+			switch i.Type {
+			case discordgo.InteractionApplicationCommand:
+				if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
+					h(s, i)
+				}
+			case discordgo.InteractionMessageComponent:
+				// custom IDs may carry state after a colon
+				id, _, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+				if h, ok := componentHandlers[id]; ok {
+					h(s, i)
+				}
 			}
 		},
 	)
@@ -131,6 +265,9 @@ func Open(dg *discordgo.Session, dh discordHandler) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go dh.CheckNewEntries(ctx)
+	go dh.Dispatch(ctx)
+	go dh.PruneEntries(ctx)
+	go serveWebSub(ctx, ws)
 
 	// Set the playing status.
 	_ = dg.UpdateGameStatus(0, "/subscribe <URL>")
@@ -140,5 +277,7 @@ func Open(dg *discordgo.Session, dh discordHandler) {
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
+	// cancel in-flight fetches before closing the session
+	cancel()
 	dg.Close()
 }
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type webSubHandler interface {
+	Verify(w http.ResponseWriter, r *http.Request)
+	Receive(w http.ResponseWriter, r *http.Request)
+}
+
+// WebSub is where the WebSub callbacks are served. It is disabled when CallbackURL is empty.
+type WebSub struct {
+	// Addr is the address listened on
+	Addr string
+	// CallbackURL is the public URL of the callbacks, whose path is served under Addr
+	CallbackURL string
+	Handler     webSubHandler
+}
+
+// serveWebSub serves the WebSub callbacks until ctx is done.
+func serveWebSub(ctx context.Context, ws WebSub) {
+	if ws.CallbackURL == "" {
+		return
+	}
+	u, err := url.Parse(ws.CallbackURL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("invalid WebSub callback URL %q: %v", ws.CallbackURL, err))
+		return
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+path+"/{id}", ws.Handler.Verify)
+	mux.HandleFunc("POST "+path+"/{id}", ws.Handler.Receive)
+	srv := &http.Server{Addr: ws.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error(fmt.Sprintf("error serving WebSub callbacks: %v", err))
+	}
+}
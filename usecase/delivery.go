@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+)
+
+// DeliveryRetry is how the deliveries failing to be sent are retried
+type DeliveryRetry struct {
+	// Interval is the wait before the first retry, doubled on every failure
+	Interval time.Duration
+	// MaxAttempts is how many times a delivery is attempted before giving up
+	MaxAttempts int
+}
+
+type DeliveryUsecase struct {
+	dr    repository.DeliveryRepository
+	rr    repository.RssEnrtyRepository
+	retry DeliveryRetry
+}
+
+func NewDeliveryUsecase(dr repository.DeliveryRepository, rr repository.RssEnrtyRepository, retry DeliveryRetry) DeliveryUsecase {
+	return DeliveryUsecase{dr: dr, rr: rr, retry: retry}
+}
+
+// Sent records that delivery was posted or edited as messageID, so a later update of its entry can edit it.
+func (u DeliveryUsecase) Sent(delivery model.Delivery, messageID string) error {
+	delivery.Status = model.DeliverySent
+	delivery.MessageID = messageID
+	delivery.LastError = ""
+	_, err := u.dr.Save(delivery)
+	return err
+}
+
+// Failed records a failed attempt of delivery and schedules the next one.
+func (u DeliveryUsecase) Failed(delivery model.Delivery, cause error) error {
+	delivery.Status = model.DeliveryFailed
+	delivery.Attempts++
+	delivery.LastError = cause.Error()
+	delivery.NextAttemptAt = time.Now().Add(u.backoff(delivery.Attempts))
+	if delivery.Attempts >= u.retry.MaxAttempts {
+		slog.Warn(fmt.Sprintf("gave up delivering %s to channel %s after %d attempts: %v", delivery.Entry.EntryLink, delivery.Subscription.ChannelID, delivery.Attempts, cause))
+	}
+	_, err := u.dr.Save(delivery)
+	return err
+}
+
+// backoff returns the wait before the next attempt after the given number of failed ones.
+func (u DeliveryUsecase) backoff(attempts int) time.Duration {
+	// stop doubling well before the duration overflows
+	return u.retry.Interval << min(attempts-1, 20)
+}
+
+// Due returns the deliveries to send to the subscriptions of s, queued or to retry, along with their subscription and entry.
+// The deliveries to a disabled subscription wait for it to be enabled again.
+func (u DeliveryUsecase) Due(s []model.Subscription) ([]model.Delivery, error) {
+	deliveries, err := u.dr.FindDue(time.Now(), u.retry.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	subs := map[uint]model.Subscription{}
+	for _, sub := range enabled(s) {
+		subs[sub.ID] = sub
+	}
+	ids := make([]uint, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.EntryID)
+	}
+	found, err := u.rr.FindByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[uint]model.RssEntry{}
+	for _, entry := range found {
+		entries[entry.ID] = entry
+	}
+	res := []model.Delivery{}
+	for _, delivery := range deliveries {
+		sub, ok := subs[delivery.SubscriptionID]
+		if !ok {
+			continue
+		}
+		entry, ok := entries[delivery.EntryID]
+		if !ok {
+			continue
+		}
+		delivery.Subscription, delivery.Entry = sub, entry
+		res = append(res, delivery)
+	}
+	return res, nil
+}
@@ -0,0 +1,118 @@
+package usecase_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
+	"github.com/dev-shimada/discord-rss-bot/usecase"
+)
+
+func TestDeliveryRetry(t *testing.T) {
+	sub := model.Subscription{ID: 1, ChannelID: "123", FeedID: 1, Status: model.SubscriptionActive}
+	tests := []struct {
+		name    string
+		retry   usecase.DeliveryRetry
+		sub     model.Subscription
+		deliver func(u usecase.DeliveryUsecase, d model.Delivery) error
+		want    int
+	}{
+		{
+			name:  "sent",
+			retry: usecase.DeliveryRetry{Interval: time.Nanosecond, MaxAttempts: 3},
+			sub:   sub,
+			deliver: func(u usecase.DeliveryUsecase, d model.Delivery) error {
+				return u.Sent(d, "100")
+			},
+			want: 0,
+		},
+		{
+			name:  "failed",
+			retry: usecase.DeliveryRetry{Interval: time.Nanosecond, MaxAttempts: 3},
+			sub:   sub,
+			deliver: func(u usecase.DeliveryUsecase, d model.Delivery) error {
+				return u.Failed(d, errors.New("error"))
+			},
+			want: 1,
+		},
+		{
+			name:  "failed, not due yet",
+			retry: usecase.DeliveryRetry{Interval: time.Hour, MaxAttempts: 3},
+			sub:   sub,
+			deliver: func(u usecase.DeliveryUsecase, d model.Delivery) error {
+				return u.Failed(d, errors.New("error"))
+			},
+			want: 0,
+		},
+		{
+			name:  "out of attempts",
+			retry: usecase.DeliveryRetry{Interval: time.Nanosecond, MaxAttempts: 1},
+			sub:   sub,
+			deliver: func(u usecase.DeliveryUsecase, d model.Delivery) error {
+				return u.Failed(d, errors.New("error"))
+			},
+			want: 0,
+		},
+		{
+			name:  "queued or interrupted",
+			retry: usecase.DeliveryRetry{Interval: time.Nanosecond, MaxAttempts: 3},
+			sub:   sub,
+			deliver: func(_ usecase.DeliveryUsecase, _ model.Delivery) error {
+				return nil
+			},
+			want: 1,
+		},
+		{
+			name:  "disabled subscription",
+			retry: usecase.DeliveryRetry{Interval: time.Nanosecond, MaxAttempts: 3},
+			sub:   model.Subscription{ID: 1, ChannelID: "123", FeedID: 1, Status: model.SubscriptionDisabled},
+			deliver: func(u usecase.DeliveryUsecase, d model.Delivery) error {
+				return u.Failed(d, errors.New("error"))
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := databasetest.NewDB(t)
+			defer database.CloseDB(db)
+			rr := persistence.NewRssEntryPersistence(db)
+			u := usecase.NewDeliveryUsecase(persistence.NewDeliveryPersistence(db), rr, tt.retry)
+
+			// prepare
+			entry := model.RssEntry{FeedID: 1, Identity: "guid:1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: time.Now()}
+			queued := []model.Delivery{{SubscriptionID: 1, Status: model.DeliveryPending, Action: model.DeliveryPost, NextAttemptAt: time.Now(), Entry: entry}}
+			if err := rr.Save([]model.RssEntry{entry}, nil, queued); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			due, err := u.Due([]model.Subscription{sub})
+			if err != nil || len(due) != 1 {
+				t.Fatalf("want: the queued delivery, got: %v, %v", due, err)
+			}
+
+			// test
+			if err := tt.deliver(u, due[0]); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+			got, err := u.Due([]model.Subscription{tt.sub})
+
+			// assert
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("want: %v, got: %v", tt.want, got)
+			}
+			if tt.want > 0 && (got[0].Subscription.ChannelID != "123" || got[0].Entry.EntryLink != "https://example.com/entry1") {
+				t.Errorf("want: the subscription and the entry, got: %v", got[0])
+			}
+		})
+	}
+}
@@ -1,4 +1,23 @@
 package usecase
 
+import (
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/mmcdole/gofeed"
+)
+
 var Diff = diff
 var Unique = unique
+var Changed = changed
+var Identity = identity
+var CanonicalURL = canonicalURL
+var NewEntry = newEntry
+
+func (s Schedule) Next(now time.Time, feed model.Feed, interval, maxAge, retryAfter time.Duration, published []time.Time) time.Time {
+	return s.next(now, feed, interval, pollHints{maxAge: maxAge, retryAfter: retryAfter}, published)
+}
+
+func ContentHash(title, link string) string {
+	return contentHash(&gofeed.Item{Title: title, Link: link})
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// PollLimits caps how many feeds are fetched at the same time, in total and per host.
+// Values below 1 are treated as 1.
+type PollLimits struct {
+	Concurrency        int
+	PerHostConcurrency int
+}
+
+type pool struct {
+	global  chan struct{}
+	perHost int
+	mu      sync.Mutex
+	hosts   map[string]chan struct{}
+}
+
+func newPool(l PollLimits) *pool {
+	return &pool{
+		global:  make(chan struct{}, max(l.Concurrency, 1)),
+		perHost: max(l.PerHostConcurrency, 1),
+		hosts:   map[string]chan struct{}{},
+	}
+}
+
+// acquire takes a host slot first, so a global slot is never held while waiting for a busy host.
+func (p *pool) acquire(ctx context.Context, host string) error {
+	h := p.host(host)
+	select {
+	case h <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case p.global <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		<-h
+		return ctx.Err()
+	}
+}
+
+func (p *pool) release(host string) {
+	<-p.global
+	<-p.host(host)
+}
+
+func (p *pool) host(host string) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.hosts[host]
+	if !ok {
+		h = make(chan struct{}, p.perHost)
+		p.hosts[host] = h
+	}
+	return h
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return strings.ToLower(u.Hostname())
+}
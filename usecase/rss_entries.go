@@ -1,115 +1,700 @@
 package usecase
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 )
 
 type RssEntriesUsecase struct {
 	rr         repository.RssEnrtyRepository
+	fr         repository.FeedRepository
+	sr         repository.SubscriptionRepository
 	rssFetcher repository.RssFetcher
+	limits     PollLimits
+	schedule   Schedule
+	retention  Retention
+	// mu serializes polls and pushes, so the same entry is never saved twice
+	mu *sync.Mutex
 }
 
-func NewRssEntriesUsecase(rr repository.RssEnrtyRepository, rss repository.RssFetcher) RssEntriesUsecase {
-	return RssEntriesUsecase{rr: rr, rssFetcher: rss}
+// Retention is how long the entries are kept once gone from their feed
+type Retention struct {
+	// MaxAge is how long an entry is kept after it was first saved
+	MaxAge time.Duration
+	// MaxEntries is how many of the latest published entries of a feed are kept
+	MaxEntries int
+	// MaxAttempts is how many times a delivery is attempted, after which it no longer keeps its entry
+	MaxAttempts int
 }
 
-func (f RssEntriesUsecase) Check(s model.Subscription) model.RssEntry {
-	if s.RSSURL == "" {
+func NewRssEntriesUsecase(rr repository.RssEnrtyRepository, fr repository.FeedRepository, sr repository.SubscriptionRepository, rss repository.RssFetcher, limits PollLimits, schedule Schedule, retention Retention) RssEntriesUsecase {
+	return RssEntriesUsecase{rr: rr, fr: fr, sr: sr, rssFetcher: rss, limits: limits, schedule: schedule, retention: retention, mu: &sync.Mutex{}}
+}
+
+func (f RssEntriesUsecase) Check(ctx context.Context, s model.Subscription) model.RssEntry {
+	if s.Feed.URL == "" {
 		return model.RssEntry{}
 	}
-	items, err := f.rssFetcher.Fetch(s.RSSURL)
+	result, err := f.rssFetcher.Fetch(ctx, model.Feed{URL: s.Feed.URL})
 	if err != nil {
-		slog.Warn(fmt.Sprintf("failed to fetch RSS: %v", err))
+		slog.Warn(fmt.Sprintf("failed to fetch RSS %s: %v", s.Feed.URL, err))
 		return model.RssEntry{}
 	}
+	items := result.Items
 	if len(items) == 0 {
 		return model.RssEntry{}
 	}
-	item := items[0]
-	return model.RssEntry{
-		RSSURL:      s.RSSURL,
-		EntryTitle:  item.Title,
-		EntryLink:   item.Link,
-		PublishedAt: *item.PublishedParsed,
-	}
+	return newEntry(s.FeedID, items[0], time.Now())
 }
 
-func (f RssEntriesUsecase) CheckNewEntries(s []model.Subscription) []model.RssEntry {
+// CheckNewEntries fetches every due feed once, however many channels subscribe to it,
+// and returns the entries not seen before and those updated, marked as such,
+// along with the notices of subscriptions broken or recovered.
+// The deliveries of the entries to each subscription are queued for the dispatcher.
+func (f RssEntriesUsecase) CheckNewEntries(ctx context.Context, s []model.Subscription) ([]model.RssEntry, []model.Notice) {
+	s = enabled(s)
 	if len(s) == 0 {
-		return []model.RssEntry{}
+		return []model.RssEntry{}, nil
+	}
+	now := time.Now()
+	groups := dueFeeds(groupByFeed(s), now)
+	feeds := make([]model.Feed, len(groups))
+	for i, g := range groups {
+		feeds[i] = g.feed
 	}
-	res := make([]model.RssEntry, 0, len(s))
+	return f.process(s, groups, f.fetchAll(ctx, feeds), now)
+}
 
-	for _, sub := range s {
-		items, err := f.rssFetcher.Fetch(sub.RSSURL)
-		if err != nil {
-			slog.Warn(fmt.Sprintf("failed to fetch RSS: %v", err))
+// process saves the entries not seen before of the checked feeds, then the fetch state and the schedule of the feeds.
+// results[i] is the result of groups[i], and s are the subscriptions of the groups.
+func (f RssEntriesUsecase) process(s []model.Subscription, groups []feedGroup, results []fetched, now time.Time) ([]model.RssEntry, []model.Notice) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.processLocked(s, groups, results, now)
+}
+
+// processLocked is process for a caller already holding mu.
+func (f RssEntriesUsecase) processLocked(s []model.Subscription, groups []feedGroup, results []fetched, now time.Time) ([]model.RssEntry, []model.Notice) {
+	res := make([]model.RssEntry, 0, len(results))
+	checked := []int{}
+	// identities of the items in each feed, including the legacy ones of their links
+	seen := map[uint][]string{}
+	// undated items already there when a feed is first fetched are recorded without being delivered
+	baseline := map[entryKey]bool{}
+
+	// merge in feed order regardless of which fetch finished first
+	for i, r := range results {
+		if !r.checked {
 			continue
 		}
-		for _, item := range items {
-			// skip if the item is older than the subscribed date
-			if sub.CreatedAt.After(*item.PublishedParsed) {
+		checked = append(checked, i)
+		feed := r.feed
+		for _, item := range r.items {
+			entry := newEntry(feed.ID, item, now)
+			entry.SeenAt = now
+			seen[feed.ID] = append(seen[feed.ID], entry.Identity, legacyIdentity(entry.EntryLink))
+			if item.PublishedParsed == nil && item.UpdatedParsed == nil && feed.LastSuccessAt.IsZero() {
+				baseline[keyOf(entry)] = true
+			}
+			// skip if the item is older than the earliest subscription of the feed
+			if groups[i].since.After(entry.PublishedAt) {
 				continue
 			}
-			res = append(res, model.RssEntry{
-				RSSURL:      sub.RSSURL,
-				EntryTitle:  item.Title,
-				EntryLink:   item.Link,
-				PublishedAt: *item.PublishedParsed,
-			})
+			res = append(res, entry)
 		}
 	}
-	cpRes := make([]model.RssEntry, len(s))
-	copy(cpRes, res)
-
-	existingEntries := f.rr.Find(cpRes)
+	existingEntries, err := f.existing(seen)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to load RSS entries: %v", err))
+		return nil, nil
+	}
+	// mark the live entries before anything new, so the pruner never sees them otherwise
+	if err := f.rr.MarkSeen(seen, now); err != nil {
+		slog.Error(fmt.Sprintf("failed to mark RSS entries seen: %v", err))
+		return nil, nil
+	}
 	newEntries := diff(res, existingEntries)
 	uniqueNewEntries := unique(newEntries)
+	changedEntries := unique(changed(res, existingEntries))
+	// the entries and their deliveries are saved together, an update not saved is found again next time
+	deliveries := fanOut(s, deliverable(uniqueNewEntries, changedEntries, baseline), now)
+	if err := f.rr.Save(uniqueNewEntries, changedEntries, deliveries); err != nil {
+		slog.Error(fmt.Sprintf("failed to save RSS entries: %v", err))
+		return nil, nil
+	}
+
+	// a push tells nothing of how the feed itself responds, so only what its document tells is saved
+	polled := []int{}
+	for _, i := range checked {
+		if !results[i].pushed {
+			polled = append(polled, i)
+			continue
+		}
+		if err := f.fr.UpdateDocument(results[i].feed); err != nil {
+			slog.Warn(fmt.Sprintf("failed to save feed %d: %v", results[i].feed.ID, err))
+		}
+	}
 
-	err := f.rr.Create(uniqueNewEntries)
+	// learn the cadence including the entries just saved
+	ids := make([]uint, len(polled))
+	for k, i := range polled {
+		ids[k] = results[i].feed.ID
+	}
+	published, err := f.rr.RecentPublishedAt(ids, historySize)
 	if err != nil {
-		slog.Error(fmt.Sprintf("failed to save RSS entries: %v", err))
-		return nil
+		slog.Warn(fmt.Sprintf("failed to load the publication history: %v", err))
+	}
+	checkedFeeds := make([]model.Feed, 0, len(polled))
+	gone := map[uint]bool{}
+	for _, i := range polled {
+		feed := results[i].feed
+		err := results[i].err
+		if err != nil {
+			feed.ConsecutiveFailures++
+			feed.LastError = err.Error()
+		} else {
+			feed.ConsecutiveFailures = 0
+			feed.LastError = ""
+			feed.LastSuccessAt = now
+		}
+		if statusCode(err) == http.StatusNotFound {
+			feed.ConsecutiveNotFound++
+		} else {
+			feed.ConsecutiveNotFound = 0
+		}
+		if statusCode(err) == http.StatusGone || f.schedule.NotFoundThreshold > 0 && feed.ConsecutiveNotFound >= f.schedule.NotFoundThreshold {
+			gone[feed.ID] = true
+		}
+		feed.LastCheckedAt = now
+		if results[i].whole {
+			feed.LiveAt = now
+		}
+		feed.NextDueAt = f.schedule.next(now, feed, groups[i].interval, results[i].hints, published[feed.ID])
+
+		feed, merged := f.move(feed, results[i].movedTo)
+		if merged {
+			continue
+		}
+		checkedFeeds = append(checkedFeeds, feed)
+	}
+	// save the validators and the schedule only after the entries,
+	// otherwise a failed save would hide them behind a 304 or the next due time
+	if err := f.fr.Save(checkedFeeds); err != nil {
+		slog.Warn(fmt.Sprintf("failed to save feeds: %v", err))
 	}
-	return uniqueNewEntries
+	return deliverable(uniqueNewEntries, changedEntries, baseline), f.updateStatus(s, checkedFeeds, gone)
 }
 
-func diff(s1, s2 []model.RssEntry) []model.RssEntry {
-	diffSlice := []model.RssEntry{}
-	cmpMap := map[string]int{}
+// deliverable returns the new entries but those of the baseline, followed by the updated ones.
+func deliverable(created, changed []model.RssEntry, baseline map[entryKey]bool) []model.RssEntry {
+	res := slices.DeleteFunc(slices.Clone(created), func(e model.RssEntry) bool {
+		return baseline[keyOf(e)]
+	})
+	for _, e := range changed {
+		if e.Updated {
+			res = append(res, e)
+		}
+	}
+	return res
+}
 
-	for _, v := range s2 {
-		cmpMap[v.EntryLink] += 1
+// fanOut returns the deliveries of entries to the subscriptions of s, due at now.
+// A subscription gets the entries of its feed published since it was created,
+// and the updates of those as it asks.
+func fanOut(s []model.Subscription, entries []model.RssEntry, now time.Time) []model.Delivery {
+	res := []model.Delivery{}
+	for _, sub := range enabled(s) {
+		for _, entry := range entries {
+			// a feed shared with older subscriptions may return entries published before this one
+			if sub.FeedID != entry.FeedID || sub.CreatedAt.After(entry.PublishedAt) {
+				continue
+			}
+			action := model.DeliveryPost
+			if entry.Updated {
+				switch sub.OnUpdate {
+				case model.UpdateIgnore:
+					continue
+				case model.UpdateRepost:
+				default:
+					action = model.DeliveryEdit
+				}
+			}
+			res = append(res, model.Delivery{SubscriptionID: sub.ID, Status: model.DeliveryPending, Action: action, Nonce: nonce(), NextAttemptAt: now, Entry: entry})
+		}
 	}
+	return res
+}
 
-	for _, v := range s1 {
-		t, ok := cmpMap[v.EntryLink]
+// nonce returns a random nonce of a message, Discord allows up to 25 characters
+func nonce() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// existing returns the saved entries of each feed with any of the identities
+func (f RssEntriesUsecase) existing(identities map[uint][]string) ([]model.RssEntry, error) {
+	res := []model.RssEntry{}
+	for feedID, ids := range identities {
+		entries, err := f.rr.FindByIdentities(feedID, ids)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entries...)
+	}
+	return res, nil
+}
+
+// Prune deletes the entries past the retention, but those still in their feed or not delivered yet
+// unless their delivery was given up.
+// It is serialized with polls and pushes, so an entry seen by one is never pruned in the meantime.
+func (f RssEntriesUsecase) Prune() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pruned, err := f.rr.Prune(time.Now().Add(-f.retention.MaxAge), f.retention.MaxEntries, f.retention.MaxAttempts)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to prune RSS entries: %v", err))
+	}
+	if pruned > 0 {
+		slog.Info(fmt.Sprintf("pruned %d RSS entries", pruned))
+	}
+}
+
+// statusCode returns the HTTP status of a failed fetch, zero when it did not get a response
+func statusCode(err error) int {
+	var httpErr gofeed.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	return 0
+}
+
+// move points feed at the URL it permanently moved to, so its entries and subscriptions keep following it.
+// When another feed already has that URL, feed is merged into it and merged is true.
+func (f RssEntriesUsecase) move(feed model.Feed, movedTo string) (_ model.Feed, merged bool) {
+	if movedTo == "" {
+		return feed, false
+	}
+	rssURL, err := canonicalURL(movedTo)
+	if err != nil || rssURL == feed.URL {
+		return feed, false
+	}
+	// the credentials and the custom headers would follow the feed to the other host on every poll,
+	// so such a feed keeps its URL, whose redirects are fetched without them
+	if feed.Options.HostBound() && !sameHost(feed.URL, rssURL) {
+		slog.Warn(fmt.Sprintf("RSS %s moved to %s on another host, kept as it is to not send its credentials there", feed.URL, rssURL))
+		return feed, false
+	}
+	existing, err := f.fr.FindBySource(model.Feed{URL: rssURL, Selector: feed.Selector, Options: feed.Options})
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		slog.Info(fmt.Sprintf("RSS %s moved to %s", feed.URL, rssURL))
+		feed.URL = rssURL
+		return feed, false
+	case err != nil:
+		slog.Warn(fmt.Sprintf("failed to find feed %s: %v", rssURL, err))
+		return feed, false
+	}
+	if err := f.fr.Merge(feed.ID, existing.ID); err != nil {
+		slog.Warn(fmt.Sprintf("failed to merge RSS %s into %s: %v", feed.URL, rssURL, err))
+		return feed, false
+	}
+	slog.Info(fmt.Sprintf("RSS %s moved to %s, merged into the existing feed", feed.URL, rssURL))
+	return feed, true
+}
+
+// sameHost reports whether the URLs a and b are on the same host and port.
+func sameHost(a, b string) bool {
+	u, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	v, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, v.Host)
+}
+
+// updateStatus disables the subscriptions of the feeds gone, marks those of the feeds failing
+// past the threshold broken, and those of the feeds fetched again active.
+// A notice is returned for every change, so each channel is told once.
+func (f RssEntriesUsecase) updateStatus(s []model.Subscription, feeds []model.Feed, gone map[uint]bool) []model.Notice {
+	byID := map[uint]model.Feed{}
+	for _, feed := range feeds {
+		byID[feed.ID] = feed
+	}
+	changes := map[string][]uint{}
+	pending := map[string][]model.Notice{}
+	change := func(sub model.Subscription, status, content string) {
+		changes[status] = append(changes[status], sub.ID)
+		pending[status] = append(pending[status], model.Notice{ChannelID: sub.ChannelID, Content: content})
+	}
+	for _, sub := range s {
+		feed, ok := byID[sub.FeedID]
 		if !ok {
-			diffSlice = append(diffSlice, v)
 			continue
 		}
-		if t == 1 {
-			delete(cmpMap, v.EntryLink)
-		} else {
-			cmpMap[v.EntryLink] -= 1
+		switch {
+		case gone[feed.ID]:
+			change(sub, model.SubscriptionDisabled, fmt.Sprintf("RSS feed is gone, so its subscription is disabled: %s\n%s\nUse /unsubscribe to remove it.", feed.URL, feed.LastError))
+		case f.schedule.FailureThreshold > 0 && feed.ConsecutiveFailures >= f.schedule.FailureThreshold && sub.Status != model.SubscriptionBroken:
+			change(sub, model.SubscriptionBroken, fmt.Sprintf("RSS feed failed %d times in a row and is now checked less often: %s\n%s", feed.ConsecutiveFailures, feed.URL, feed.LastError))
+		case feed.ConsecutiveFailures == 0 && sub.Status == model.SubscriptionBroken:
+			change(sub, model.SubscriptionActive, fmt.Sprintf("RSS feed is working again: %s", feed.URL))
+		}
+	}
+
+	// a notice is only sent once its status is saved, otherwise it is sent again next time
+	notices := []model.Notice{}
+	for _, status := range []string{model.SubscriptionDisabled, model.SubscriptionBroken, model.SubscriptionActive} {
+		if err := f.sr.UpdateStatus(changes[status], status); err != nil {
+			slog.Error(fmt.Sprintf("failed to mark subscriptions %s: %v", status, err))
+			continue
+		}
+		notices = append(notices, pending[status]...)
+	}
+	return notices
+}
+
+// enabled drops the disabled subscriptions, whose feeds are gone.
+func enabled(s []model.Subscription) []model.Subscription {
+	return slices.DeleteFunc(slices.Clone(s), func(sub model.Subscription) bool {
+		return sub.Status == model.SubscriptionDisabled
+	})
+}
+
+// feedGroup is a feed with what its subscriptions ask of it
+type feedGroup struct {
+	feed model.Feed
+	// since is the creation time of the earliest subscription
+	since time.Time
+	// interval is the shortest interval asked by a subscription, zero for the default
+	interval time.Duration
+}
+
+// groupByFeed returns the distinct feeds of s in order of appearance.
+func groupByFeed(s []model.Subscription) []feedGroup {
+	groups := []feedGroup{}
+	index := map[uint]int{}
+	for _, sub := range s {
+		i, ok := index[sub.FeedID]
+		if !ok {
+			index[sub.FeedID] = len(groups)
+			groups = append(groups, feedGroup{feed: sub.Feed, since: sub.CreatedAt, interval: sub.Interval})
+			continue
+		}
+		if sub.CreatedAt.Before(groups[i].since) {
+			groups[i].since = sub.CreatedAt
+		}
+		if sub.Interval > 0 && (groups[i].interval == 0 || sub.Interval < groups[i].interval) {
+			groups[i].interval = sub.Interval
 		}
 	}
-	return diffSlice
+	return groups
+}
+
+type fetched struct {
+	items []*gofeed.Item
+	feed  model.Feed
+	hints pollHints
+	err   error
+	// movedTo is the URL the feed permanently moved to, empty when it did not
+	movedTo string
+	// checked is false when the fetch was never sent or was cut by the end of the cycle
+	checked bool
+	// whole is true when items are all the entries of the feed, read from its whole document
+	whole bool
+	// pushed is true when items were pushed by a hub, the feed itself not being fetched
+	pushed bool
+}
+
+// fetchAll fetches every feed within the pool limits.
+// The result at index i belongs to feeds[i] and carries its updated metadata and validators.
+// Items are empty when the fetch failed or the feed was not modified.
+// A fetch cut by the end of the cycle is not a failure of the feed, so it is reported as not checked.
+func (f RssEntriesUsecase) fetchAll(ctx context.Context, feeds []model.Feed) []fetched {
+	res := make([]fetched, len(feeds))
+	p := newPool(f.limits)
+	var wg sync.WaitGroup
+	for i, feed := range feeds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			host := hostOf(feed.URL)
+			if err := p.acquire(ctx, host); err != nil {
+				slog.Warn(fmt.Sprintf("skipped RSS %s: %v", feed.URL, err))
+				return
+			}
+			defer p.release(host)
+
+			result, err := f.rssFetcher.Fetch(ctx, feed)
+			if err != nil && ctx.Err() != nil {
+				slog.Warn(fmt.Sprintf("skipped RSS %s: %v", feed.URL, err))
+				return
+			}
+			hints := pollHints{maxAge: result.MaxAge, retryAfter: result.RetryAfter}
+			res[i] = fetched{feed: feed, hints: hints, err: err, checked: true}
+			if err != nil {
+				slog.Warn(fmt.Sprintf("failed to fetch RSS %s: %v", feed.URL, err))
+				return
+			}
+			if result.NotModified {
+				res[i].movedTo = result.MovedTo
+				return
+			}
+			feed.Title = result.Title
+			feed.SiteLink = result.SiteLink
+			feed.IconURL = result.IconURL
+			feed.ETag = result.ETag
+			feed.LastModified = result.LastModified
+			feed.TTL = result.TTL
+			feed.SkipHours = result.SkipHours
+			feed.SkipDays = result.SkipDays
+			if len(result.Repairs) > 0 && len(feed.Repairs) == 0 {
+				slog.Warn(fmt.Sprintf("RSS %s is malformed, parsed once repaired: %s", feed.URL, strings.Join(result.Repairs, ", ")))
+			}
+			feed.Repairs = result.Repairs
+			feed.HubURL, feed.TopicURL = result.HubURL, ""
+			if result.HubURL != "" {
+				feed.TopicURL = cmp.Or(result.SelfURL, feed.URL)
+			}
+			res[i] = fetched{items: result.Items, feed: feed, hints: hints, movedTo: result.MovedTo, checked: true, whole: true}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// entryKey identifies an entry across the feeds
+type entryKey struct {
+	feedID   uint
+	identity string
+}
+
+func keyOf(e model.RssEntry) entryKey {
+	return entryKey{feedID: e.FeedID, identity: e.Identity}
+}
+
+// diff returns the entries of s1 not in s2, matching them by feed and identity.
+// An entry saved before identities existed matches the entries with its link.
+func diff(s1, s2 []model.RssEntry) []model.RssEntry {
+	seen := map[entryKey]bool{}
+	for _, v := range s2 {
+		seen[keyOf(v)] = true
+	}
+	res := []model.RssEntry{}
+	for _, v := range s1 {
+		if seen[keyOf(v)] || seen[entryKey{feedID: v.FeedID, identity: legacyIdentity(v.EntryLink)}] {
+			continue
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// changed returns the entries of s1 whose content differs from their saved entry in s2, carrying its ID and dates.
+// They are marked updated, unless the saved entry predates content hashes and only needs its hash filled in.
+func changed(s1, s2 []model.RssEntry) []model.RssEntry {
+	saved := map[entryKey]model.RssEntry{}
+	for _, v := range s2 {
+		saved[keyOf(v)] = v
+	}
+	res := []model.RssEntry{}
+	for _, v := range s1 {
+		old, ok := saved[keyOf(v)]
+		if !ok || old.ContentHash == v.ContentHash {
+			continue
+		}
+		v.ID, v.PublishedAt, v.CreatedAt = old.ID, old.PublishedAt, old.CreatedAt
+		v.Updated = old.ContentHash != ""
+		res = append(res, v)
+	}
+	return res
 }
 
 func unique(s []model.RssEntry) []model.RssEntry {
-	m := map[string]struct{}{}
+	m := map[entryKey]struct{}{}
 	res := []model.RssEntry{}
 
 	for _, v := range s {
-		if _, ok := m[v.EntryLink]; !ok {
-			m[v.EntryLink] = struct{}{}
+		if _, ok := m[keyOf(v)]; !ok {
+			m[keyOf(v)] = struct{}{}
 			res = append(res, v)
 		}
 	}
 	return res
 }
+
+// newEntry builds the entry of an item of the feed, dated by its publication, else its last update,
+// else now, when it is first seen.
+func newEntry(feedID uint, item *gofeed.Item, now time.Time) model.RssEntry {
+	published := now
+	switch {
+	case item.PublishedParsed != nil:
+		published = *item.PublishedParsed
+	case item.UpdatedParsed != nil:
+		published = *item.UpdatedParsed
+	}
+	return model.RssEntry{
+		FeedID:      feedID,
+		Identity:    identity(item),
+		EntryTitle:  item.Title,
+		EntryLink:   item.Link,
+		PublishedAt: published,
+		Description: item.Description,
+		Content:     item.Content,
+		Authors:     authors(item),
+		Categories:  item.Categories,
+		Enclosures:  enclosures(item),
+		ImageURL:    imageURL(item),
+		Media:       media(item.Extensions["media"]),
+		ContentHash: contentHash(item),
+	}
+}
+
+// authors returns the authors of an item, gofeed giving a single one with Author when they are not listed.
+func authors(item *gofeed.Item) []model.Author {
+	people := item.Authors
+	if len(people) == 0 && item.Author != nil {
+		people = []*gofeed.Person{item.Author}
+	}
+	var res []model.Author
+	for _, p := range people {
+		if p != nil && (p.Name != "" || p.Email != "") {
+			res = append(res, model.Author{Name: p.Name, Email: p.Email})
+		}
+	}
+	return res
+}
+
+// enclosures returns the files attached to an item, an invalid length being taken as unknown.
+func enclosures(item *gofeed.Item) []model.Enclosure {
+	var res []model.Enclosure
+	for _, e := range item.Enclosures {
+		if e == nil || e.URL == "" {
+			continue
+		}
+		length, _ := strconv.ParseInt(strings.TrimSpace(e.Length), 10, 64)
+		res = append(res, model.Enclosure{URL: e.URL, Type: e.Type, Length: max(length, 0)})
+	}
+	return res
+}
+
+// media returns the media:content and media:thumbnail of an item, including those grouped by media:group
+// and the thumbnails of a media:content.
+func media(exts map[string][]ext.Extension) []model.Media {
+	var res []model.Media
+	for _, e := range exts["content"] {
+		if m, ok := mediaOf(e, false); ok {
+			res = append(res, m)
+		}
+		for _, t := range e.Children["thumbnail"] {
+			if m, ok := mediaOf(t, true); ok {
+				res = append(res, m)
+			}
+		}
+	}
+	for _, e := range exts["thumbnail"] {
+		if m, ok := mediaOf(e, true); ok {
+			res = append(res, m)
+		}
+	}
+	for _, e := range exts["group"] {
+		res = append(res, media(e.Children)...)
+	}
+	return res
+}
+
+func mediaOf(e ext.Extension, thumbnail bool) (model.Media, bool) {
+	if e.Attrs["url"] == "" {
+		return model.Media{}, false
+	}
+	width, _ := strconv.Atoi(e.Attrs["width"])
+	height, _ := strconv.Atoi(e.Attrs["height"])
+	return model.Media{URL: e.Attrs["url"], Type: e.Attrs["type"], Medium: e.Attrs["medium"], Width: width, Height: height, Thumbnail: thumbnail}, true
+}
+
+// imageURL returns the image of an item, else its first thumbnail, else its first image among its media and enclosures.
+func imageURL(item *gofeed.Item) string {
+	if item.Image != nil && item.Image.URL != "" {
+		return item.Image.URL
+	}
+	all := media(item.Extensions["media"])
+	for _, m := range all {
+		if m.Thumbnail {
+			return m.URL
+		}
+	}
+	for _, m := range all {
+		if m.Medium == "image" || strings.HasPrefix(m.Type, "image/") {
+			return m.URL
+		}
+	}
+	for _, e := range item.Enclosures {
+		if e != nil && strings.HasPrefix(e.Type, "image/") {
+			return e.URL
+		}
+	}
+	return ""
+}
+
+// contentHash returns a hash of what is posted of an item, telling when it was edited.
+// The tracking parameters of its link are left out, as they may change on every fetch.
+func contentHash(item *gofeed.Item) string {
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + withoutTracking(item.Link) + "\x00" + cmp.Or(item.Content, item.Description)))
+	return hex.EncodeToString(sum[:])
+}
+
+// identity returns the key of an item within its feed: its GUID, else its link without the tracking parameters,
+// else a hash of its title and content.
+func identity(item *gofeed.Item) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return "guid:" + guid
+	}
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return "link:" + withoutTracking(link)
+	}
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + cmp.Or(item.Content, item.Description)))
+	return "hash:" + hex.EncodeToString(sum[:])
+}
+
+// legacyIdentity is the identity given to the entries saved before identities existed, keyed by their link
+func legacyIdentity(link string) string {
+	return "legacy:" + link
+}
+
+// trackingParams are the query parameters added for analytics, which may change for the same entry
+var trackingParams = []string{"fbclid", "gclid", "mc_cid", "mc_eid", "ref"}
+
+func withoutTracking(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.RawQuery == "" {
+		return link
+	}
+	q := u.Query()
+	for name := range q {
+		if strings.HasPrefix(name, "utm_") || slices.Contains(trackingParams, name) {
+			q.Del(name)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -1,13 +1,17 @@
 package usecase_test
 
 import (
+	"context"
 	"errors"
-	"os"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
 	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
 	"github.com/dev-shimada/discord-rss-bot/usecase"
 	"github.com/google/go-cmp/cmp"
@@ -19,15 +23,98 @@ type mockRss struct {
 	mockFetch func() ([]*gofeed.Item, error)
 }
 
-func (m mockRss) Fetch(rssURL string) ([]*gofeed.Item, error) {
-	return m.mockFetch()
+func (m mockRss) Fetch(_ context.Context, _ model.Feed) (repository.FetchResult, error) {
+	items, err := m.mockFetch()
+	return repository.FetchResult{Items: items}, err
+}
+
+// mockConditionalRss answers 304 when the cached ETag matches
+type mockConditionalRss struct {
+	etag  string
+	items []*gofeed.Item
+}
+
+func (m mockConditionalRss) Fetch(_ context.Context, feed model.Feed) (repository.FetchResult, error) {
+	if feed.ETag == m.etag {
+		return repository.FetchResult{ETag: feed.ETag, NotModified: true}, nil
+	}
+	return repository.FetchResult{Items: m.items, ETag: m.etag}, nil
+}
+
+// mockConcurrentRss records the highest number of concurrent fetches in total and per host
+type mockConcurrentRss struct {
+	mu       *sync.Mutex
+	inFlight map[string]int
+	maxHost  map[string]int
+	total    *int
+	maxTotal *int
+}
+
+func (m mockConcurrentRss) Fetch(_ context.Context, feed model.Feed) (repository.FetchResult, error) {
+	rssURL := feed.URL
+	host := rssURL[:len("https://a.example.com")]
+	m.mu.Lock()
+	m.inFlight[host]++
+	*m.total++
+	m.maxHost[host] = max(m.maxHost[host], m.inFlight[host])
+	*m.maxTotal = max(*m.maxTotal, *m.total)
+	m.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.mu.Lock()
+	m.inFlight[host]--
+	*m.total--
+	m.mu.Unlock()
+
+	published := databasetest.Now()
+	return repository.FetchResult{Items: []*gofeed.Item{{Link: rssURL + "/entry", Title: rssURL, PublishedParsed: &published}}}, nil
 }
 
 // mockRssEnrtyRepository is a mock of RssEnrtyRepository interface
 type mockRssEnrtyRepository struct{}
 
-func (r mockRssEnrtyRepository) Create(_ []model.RssEntry) error          { return nil }
-func (r mockRssEnrtyRepository) Find(_ []model.RssEntry) []model.RssEntry { return nil }
+func (r mockRssEnrtyRepository) Create(_ []model.RssEntry) error { return nil }
+func (r mockRssEnrtyRepository) FindByIdentities(_ uint, _ []string) ([]model.RssEntry, error) {
+	return []model.RssEntry{}, nil
+}
+func (r mockRssEnrtyRepository) MarkSeen(_ map[uint][]string, _ time.Time) error { return nil }
+func (r mockRssEnrtyRepository) Prune(_ time.Time, _, _ int) (int, error)        { return 0, nil }
+func (r mockRssEnrtyRepository) Save(_, _ []model.RssEntry, _ []model.Delivery) error {
+	return nil
+}
+func (r mockRssEnrtyRepository) FindByIDs(_ []uint) ([]model.RssEntry, error) {
+	return []model.RssEntry{}, nil
+}
+func (r mockRssEnrtyRepository) RecentPublishedAt(_ []uint, _ int) (map[uint][]time.Time, error) {
+	return map[uint][]time.Time{}, nil
+}
+
+// mockFeedRepository is a mock of FeedRepository interface
+type mockFeedRepository struct{}
+
+func (r mockFeedRepository) Find(_ uint) (model.Feed, error) { return model.Feed{}, nil }
+func (r mockFeedRepository) FindBySource(_ model.Feed) (model.Feed, error) {
+	return model.Feed{}, nil
+}
+func (r mockFeedRepository) FindOrCreate(f model.Feed) (model.Feed, error) { return f, nil }
+func (r mockFeedRepository) Save(_ []model.Feed) error                     { return nil }
+func (r mockFeedRepository) Merge(_, _ uint) error                         { return nil }
+func (r mockFeedRepository) UpdateWebSub(_ model.Feed) error               { return nil }
+func (r mockFeedRepository) UpdateDocument(_ model.Feed) error             { return nil }
+
+// mockStatusRepository records the status updates of subscriptions
+type mockStatusRepository struct {
+	repository.SubscriptionRepository
+	status map[uint]string
+}
+
+func (r mockStatusRepository) UpdateStatus(ids []uint, status string) error {
+	for _, id := range ids {
+		r.status[id] = status
+	}
+	return nil
+}
 
 func TestCheck(t *testing.T) {
 	now := time.Now()
@@ -47,18 +134,18 @@ func TestCheck(t *testing.T) {
 		},
 		{
 			name: "multiple entries",
-			args: model.Subscription{RSSURL: "https://example.com"},
+			args: model.Subscription{FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}},
 			fetch: func() ([]*gofeed.Item, error) {
 				return []*gofeed.Item{
 					{Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &now},
 					{Link: "https://example.com/entry2", Title: "title2", PublishedParsed: &now},
 				}, nil
 			},
-			want: model.RssEntry{RSSURL: "https://example.com", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now},
+			want: model.RssEntry{FeedID: 1, Identity: "link:https://example.com/entry1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now, ContentHash: usecase.ContentHash("title1", "https://example.com/entry1")},
 		},
 		{
 			name: "fetch error",
-			args: model.Subscription{RSSURL: "https://example.com"},
+			args: model.Subscription{FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}},
 			fetch: func() ([]*gofeed.Item, error) {
 				return []*gofeed.Item{}, errors.New("error")
 			},
@@ -70,11 +157,12 @@ func TestCheck(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 
 			rr := mockRssEnrtyRepository{}
+			fr := mockFeedRepository{}
 			m := mockRss{tt.fetch}
-			f := usecase.NewRssEntriesUsecase(rr, m)
+			f := usecase.NewRssEntriesUsecase(rr, fr, mockStatusRepository{}, m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
 
 			// test
-			got := f.Check(tt.args)
+			got := f.Check(context.Background(), tt.args)
 
 			// assert
 			if !cmp.Equal(got, tt.want) {
@@ -103,7 +191,7 @@ func TestCheckNewEntries(t *testing.T) {
 		},
 		{
 			name: "new entries",
-			args: []model.Subscription{{ID: 1, ChannelID: "123", RSSURL: "https://example.com", CreatedAt: now}},
+			args: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}, CreatedAt: now}},
 			fetch: func() ([]*gofeed.Item, error) {
 				return []*gofeed.Item{
 					{Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &now},
@@ -111,13 +199,13 @@ func TestCheckNewEntries(t *testing.T) {
 				}, nil
 			},
 			want: []model.RssEntry{
-				{ID: 1, RSSURL: "https://example.com", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now},
-				{ID: 2, RSSURL: "https://example.com", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now},
+				{ID: 1, FeedID: 1, Identity: "link:https://example.com/entry1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now, ContentHash: usecase.ContentHash("title1", "https://example.com/entry1")},
+				{ID: 2, FeedID: 1, Identity: "link:https://example.com/entry2", EntryTitle: "title2", EntryLink: "https://example.com/entry2", PublishedAt: now, ContentHash: usecase.ContentHash("title2", "https://example.com/entry2")},
 			},
 		},
 		{
 			name: "drop old entries",
-			args: []model.Subscription{{ID: 1, ChannelID: "123", RSSURL: "https://example.com", CreatedAt: now}},
+			args: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}, CreatedAt: now}},
 			fetch: func() ([]*gofeed.Item, error) {
 				old := now.Add(-time.Microsecond)
 				return []*gofeed.Item{
@@ -125,38 +213,56 @@ func TestCheckNewEntries(t *testing.T) {
 					{Link: "https://example.com/entry2", Title: "title", PublishedParsed: &now},
 				}, nil
 			},
-			want: []model.RssEntry{{ID: 1, RSSURL: "https://example.com", EntryTitle: "title", EntryLink: "https://example.com/entry2", PublishedAt: now}},
+			want: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "link:https://example.com/entry2", EntryTitle: "title", EntryLink: "https://example.com/entry2", PublishedAt: now, ContentHash: usecase.ContentHash("title", "https://example.com/entry2")}},
 		},
 		{
 			name: "fetch error",
-			args: []model.Subscription{{ID: 1, ChannelID: "123", RSSURL: "https://example.com", CreatedAt: now}},
+			args: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}, CreatedAt: now}},
 			fetch: func() ([]*gofeed.Item, error) {
 				return []*gofeed.Item{}, errors.New("error")
 			},
 			want: []model.RssEntry{},
 		},
+		{
+			name: "entries sharing a link told apart by their GUID",
+			args: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}, CreatedAt: now}},
+			fetch: func() ([]*gofeed.Item, error) {
+				return []*gofeed.Item{
+					{GUID: "1", Link: "https://example.com/", Title: "title1", PublishedParsed: &now},
+					{GUID: "2", Link: "https://example.com/", Title: "title2", PublishedParsed: &now},
+				}, nil
+			},
+			want: []model.RssEntry{
+				{ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "title1", EntryLink: "https://example.com/", PublishedAt: now, ContentHash: usecase.ContentHash("title1", "https://example.com/")},
+				{ID: 2, FeedID: 1, Identity: "guid:2", EntryTitle: "title2", EntryLink: "https://example.com/", PublishedAt: now, ContentHash: usecase.ContentHash("title2", "https://example.com/")},
+			},
+		},
+		{
+			name: "dated by the update",
+			args: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: model.Feed{ID: 1, URL: "https://example.com"}, CreatedAt: now}},
+			fetch: func() ([]*gofeed.Item, error) {
+				return []*gofeed.Item{{Link: "https://example.com/entry1", Title: "title1", UpdatedParsed: &now}}, nil
+			},
+			want: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "link:https://example.com/entry1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: now, ContentHash: usecase.ContentHash("title1", "https://example.com/entry1")}},
+		},
 	}
 
-	bfDbPath := os.Getenv("DB_PATH")
-	os.Setenv("DB_PATH", "testdata/test.db")
-	defer os.Setenv("DB_PATH", bfDbPath)
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// setup
-			os.Remove("testdata/test.db")
-			db := database.NewDB()
+			db := databasetest.NewDB(t)
 			defer database.CloseDB(db)
 			rr := persistence.NewRssEntryPersistence(db)
+			fr := persistence.NewFeedPersistence(db)
 			m := mockRss{tt.fetch}
-			f := usecase.NewRssEntriesUsecase(rr, m)
+			f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
 
 			// test
-			got := f.CheckNewEntries(tt.args)
+			got, _ := f.CheckNewEntries(context.Background(), tt.args)
 
-			// remove CreatedAt field
+			// remove CreatedAt and SeenAt fields
 			for i := range got {
-				got[i].CreatedAt = time.Time{}
+				got[i].CreatedAt, got[i].SeenAt = time.Time{}, time.Time{}
 			}
 
 			// assert
@@ -167,6 +273,556 @@ func TestCheckNewEntries(t *testing.T) {
 	}
 }
 
+func TestCheckNewEntriesNotModified(t *testing.T) {
+	now := time.Now()
+	m := mockConditionalRss{
+		etag:  `"v1"`,
+		items: []*gofeed.Item{{Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &now}},
+	}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com"})
+	subs := []model.Subscription{{ID: 1, ChannelID: "123", FeedID: feed.ID, Feed: feed, CreatedAt: now}}
+
+	// test
+	first, _ := f.CheckNewEntries(context.Background(), subs)
+	// the subscriptions are reloaded with the saved validators every cycle
+	feed, _ = fr.FindBySource(model.Feed{URL: "https://example.com"})
+	subs[0].Feed = feed
+	second, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if len(first) != 1 {
+		t.Errorf("want: 1, got: %d", len(first))
+	}
+	if len(second) != 0 {
+		t.Errorf("want: 0, got: %d", len(second))
+	}
+	if feed.ETag != `"v1"` {
+		t.Errorf("want: %s, got: %s", `"v1"`, feed.ETag)
+	}
+}
+
+func TestCheckNewEntriesUndated(t *testing.T) {
+	items := []*gofeed.Item{
+		{Link: "https://example.com/entry1", Title: "title1"},
+		{Link: "https://example.com/entry2", Title: "title2"},
+	}
+	m := mockRss{mockFetch: func() ([]*gofeed.Item, error) { return items, nil }}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/news", Selector: model.Selector{Item: "li"}})
+	subs := []model.Subscription{{ID: 1, ChannelID: "123", FeedID: feed.ID, Feed: feed, CreatedAt: time.Now().Add(-time.Hour)}}
+
+	// test
+	// the items already on the page are only recorded
+	first, _ := f.CheckNewEntries(context.Background(), subs)
+	feed, _ = fr.Find(feed.ID)
+	subs[0].Feed = feed
+	items = append(items, &gofeed.Item{Link: "https://example.com/entry3", Title: "title3"})
+	before := time.Now()
+	second, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if len(first) != 0 {
+		t.Errorf("want: 0, got: %v", first)
+	}
+	if len(second) != 1 || second[0].EntryLink != "https://example.com/entry3" {
+		t.Fatalf("want: entry3, got: %v", second)
+	}
+	if second[0].PublishedAt.Before(before) {
+		t.Errorf("want: dated when first seen, got: %v", second[0].PublishedAt)
+	}
+}
+
+func TestCheckNewEntriesConcurrency(t *testing.T) {
+	subs := []model.Subscription{}
+	want := []string{}
+	for i := range 6 {
+		a := model.Feed{ID: uint(2*i + 1), URL: fmt.Sprintf("https://a.example.com/%d", i)}
+		b := model.Feed{ID: uint(2*i + 2), URL: fmt.Sprintf("https://b.example.com/%d", i)}
+		subs = append(subs, model.Subscription{FeedID: a.ID, Feed: a})
+		subs = append(subs, model.Subscription{FeedID: b.ID, Feed: b})
+	}
+	for _, sub := range subs {
+		want = append(want, sub.Feed.URL+"/entry")
+	}
+	total, maxTotal := 0, 0
+	m := mockConcurrentRss{
+		mu:       &sync.Mutex{},
+		inFlight: map[string]int{},
+		maxHost:  map[string]int{},
+		total:    &total,
+		maxTotal: &maxTotal,
+	}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, mockFeedRepository{}, mockStatusRepository{}, m, usecase.PollLimits{Concurrency: 3, PerHostConcurrency: 2}, usecase.Schedule{}, usecase.Retention{})
+
+	// test
+	got, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	links := []string{}
+	for _, entry := range got {
+		links = append(links, entry.EntryLink)
+	}
+	if !cmp.Equal(links, want) {
+		t.Errorf("Diff: %v", cmp.Diff(links, want))
+	}
+	if maxTotal > 3 {
+		t.Errorf("want: <= 3, got: %d", maxTotal)
+	}
+	for host, n := range m.maxHost {
+		if n > 2 {
+			t.Errorf("%s want: <= 2, got: %d", host, n)
+		}
+	}
+}
+
+// mockCountingRss counts the fetches per feed URL
+type mockCountingRss struct {
+	mu    *sync.Mutex
+	count map[string]int
+}
+
+func (m mockCountingRss) Fetch(_ context.Context, feed model.Feed) (repository.FetchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count[feed.URL]++
+	published := databasetest.Now()
+	return repository.FetchResult{Items: []*gofeed.Item{{Link: feed.URL + "/entry", PublishedParsed: &published}}}, nil
+}
+
+func TestCheckNewEntriesSharedFeed(t *testing.T) {
+	feed := model.Feed{ID: 1, URL: "https://example.com"}
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 1, Feed: feed},
+		{ID: 2, ChannelID: "456", FeedID: 1, Feed: feed},
+		{ID: 3, ChannelID: "789", FeedID: 1, Feed: feed},
+	}
+	m := mockCountingRss{mu: &sync.Mutex{}, count: map[string]int{}}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, mockFeedRepository{}, mockStatusRepository{}, m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
+
+	// test
+	got, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if m.count[feed.URL] != 1 {
+		t.Errorf("want: 1, got: %d", m.count[feed.URL])
+	}
+	if len(got) != 1 || got[0].FeedID != 1 {
+		t.Errorf("want: 1 entry of feed 1, got: %v", got)
+	}
+}
+
+// mockSavingFeedRepository records the saved feeds
+type mockSavingFeedRepository struct {
+	mockFeedRepository
+	saved *[]model.Feed
+}
+
+func (r mockSavingFeedRepository) Save(feeds []model.Feed) error {
+	*r.saved = append(*r.saved, feeds...)
+	return nil
+}
+
+func TestCheckNewEntriesSchedule(t *testing.T) {
+	now := time.Now()
+	overdue := model.Feed{ID: 1, URL: "https://example.com/overdue", NextDueAt: now.Add(-time.Hour)}
+	notDue := model.Feed{ID: 2, URL: "https://example.com/not-due", NextDueAt: now.Add(time.Hour)}
+	never := model.Feed{ID: 3, URL: "https://example.com/never"}
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 1, Feed: overdue, Interval: 2 * time.Hour},
+		{ID: 2, ChannelID: "456", FeedID: 1, Feed: overdue, Interval: time.Hour},
+		{ID: 3, ChannelID: "123", FeedID: 2, Feed: notDue},
+		{ID: 4, ChannelID: "123", FeedID: 3, Feed: never},
+	}
+	m := mockCountingRss{mu: &sync.Mutex{}, count: map[string]int{}}
+	saved := []model.Feed{}
+	fr := mockSavingFeedRepository{saved: &saved}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, fr, mockStatusRepository{}, m, usecase.PollLimits{}, usecase.Schedule{Interval: 10 * time.Minute}, usecase.Retention{})
+
+	// test
+	f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if diff := cmp.Diff(m.count, map[string]int{overdue.URL: 1, never.URL: 1}); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	// the shortest interval of the subscriptions wins, plus up to 10% of jitter
+	wants := map[uint]time.Duration{1: time.Hour, 3: 10 * time.Minute}
+	if len(saved) != len(wants) {
+		t.Fatalf("want: %d, got: %d", len(wants), len(saved))
+	}
+	for _, feed := range saved {
+		interval := wants[feed.ID]
+		if feed.LastCheckedAt.IsZero() {
+			t.Errorf("feed %d: LastCheckedAt is not set", feed.ID)
+		}
+		from, to := feed.LastCheckedAt.Add(interval), feed.LastCheckedAt.Add(interval+interval/10)
+		if feed.NextDueAt.Before(from) || feed.NextDueAt.After(to) {
+			t.Errorf("feed %d: want between %v and %v, got: %v", feed.ID, from, to, feed.NextDueAt)
+		}
+	}
+}
+
+// mockFailingRss fails every fetch of the feeds in fail
+type mockFailingRss struct {
+	fail map[string]bool
+}
+
+func (m mockFailingRss) Fetch(_ context.Context, feed model.Feed) (repository.FetchResult, error) {
+	if m.fail[feed.URL] {
+		return repository.FetchResult{}, errors.New("connection refused")
+	}
+	return repository.FetchResult{}, nil
+}
+
+func TestCheckNewEntriesFailures(t *testing.T) {
+	failing := model.Feed{ID: 1, URL: "https://example.com/failing", ConsecutiveFailures: 2}
+	failingLong := model.Feed{ID: 2, URL: "https://example.com/failing-long", ConsecutiveFailures: 5}
+	recovering := model.Feed{ID: 3, URL: "https://example.com/recovering", ConsecutiveFailures: 4, LastError: "connection refused"}
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 1, Feed: failing, Status: model.SubscriptionActive},
+		{ID: 2, ChannelID: "456", FeedID: 1, Feed: failing, Status: model.SubscriptionActive},
+		{ID: 3, ChannelID: "123", FeedID: 2, Feed: failingLong, Status: model.SubscriptionBroken},
+		{ID: 4, ChannelID: "123", FeedID: 3, Feed: recovering, Status: model.SubscriptionBroken},
+	}
+	m := mockFailingRss{fail: map[string]bool{failing.URL: true, failingLong.URL: true}}
+	saved := []model.Feed{}
+	fr := mockSavingFeedRepository{saved: &saved}
+	sr := mockStatusRepository{status: map[uint]string{}}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, fr, sr, m, usecase.PollLimits{}, usecase.Schedule{Interval: 10 * time.Minute, FailureThreshold: 3}, usecase.Retention{})
+
+	// test
+	_, notices := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	wantNotices := []model.Notice{
+		{ChannelID: "123", Content: "RSS feed failed 3 times in a row and is now checked less often: https://example.com/failing\nconnection refused"},
+		{ChannelID: "456", Content: "RSS feed failed 3 times in a row and is now checked less often: https://example.com/failing\nconnection refused"},
+		{ChannelID: "123", Content: "RSS feed is working again: https://example.com/recovering"},
+	}
+	if diff := cmp.Diff(notices, wantNotices); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	wantStatus := map[uint]string{1: model.SubscriptionBroken, 2: model.SubscriptionBroken, 4: model.SubscriptionActive}
+	if diff := cmp.Diff(sr.status, wantStatus); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	for _, feed := range saved {
+		switch feed.ID {
+		case 1:
+			if feed.ConsecutiveFailures != 3 || feed.LastError != "connection refused" {
+				t.Errorf("want: 3 failures, got: %d %q", feed.ConsecutiveFailures, feed.LastError)
+			}
+			// backed off twice
+			if feed.NextDueAt.Before(feed.LastCheckedAt.Add(40 * time.Minute)) {
+				t.Errorf("want: backoff, got: %v", feed.NextDueAt.Sub(feed.LastCheckedAt))
+			}
+		case 3:
+			if feed.ConsecutiveFailures != 0 || feed.LastError != "" || !feed.LastSuccessAt.Equal(feed.LastCheckedAt) {
+				t.Errorf("want: reset, got: %d %q %v", feed.ConsecutiveFailures, feed.LastError, feed.LastSuccessAt)
+			}
+		}
+	}
+}
+
+// mockMovingRss answers with the result of each feed URL
+type mockMovingRss struct {
+	results map[string]repository.FetchResult
+	errs    map[string]error
+}
+
+func (m mockMovingRss) Fetch(_ context.Context, feed model.Feed) (repository.FetchResult, error) {
+	return m.results[feed.URL], m.errs[feed.URL]
+}
+
+func TestCheckNewEntriesRepairs(t *testing.T) {
+	malformed := model.Feed{ID: 1, URL: "https://example.com/malformed"}
+	fixed := model.Feed{ID: 2, URL: "https://example.com/fixed", Repairs: []string{"truncated document"}}
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 1, Feed: malformed},
+		{ID: 2, ChannelID: "123", FeedID: 2, Feed: fixed},
+	}
+	m := mockMovingRss{results: map[string]repository.FetchResult{
+		malformed.URL: {Items: []*gofeed.Item{}, Repairs: []string{"unescaped characters"}},
+		fixed.URL:     {Items: []*gofeed.Item{}},
+	}}
+	saved := []model.Feed{}
+	fr := mockSavingFeedRepository{saved: &saved}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, fr, mockStatusRepository{status: map[uint]string{}}, m, usecase.PollLimits{}, usecase.Schedule{Interval: 10 * time.Minute}, usecase.Retention{})
+
+	// test
+	f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	want := map[uint][]string{1: {"unescaped characters"}, 2: nil}
+	got := map[uint][]string{}
+	for _, feed := range saved {
+		got[feed.ID] = feed.Repairs
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+// mockMergingFeedRepository knows the feeds in existing and records the saved and merged feeds
+type mockMergingFeedRepository struct {
+	mockFeedRepository
+	existing map[string]model.Feed
+	saved    *[]model.Feed
+	merged   *[][2]uint
+}
+
+func (r mockMergingFeedRepository) FindBySource(f model.Feed) (model.Feed, error) {
+	if feed, ok := r.existing[f.URL]; ok {
+		return feed, nil
+	}
+	return model.Feed{}, repository.ErrNotFound
+}
+func (r mockMergingFeedRepository) Save(feeds []model.Feed) error {
+	*r.saved = append(*r.saved, feeds...)
+	return nil
+}
+func (r mockMergingFeedRepository) Merge(from, into uint) error {
+	*r.merged = append(*r.merged, [2]uint{from, into})
+	return nil
+}
+
+func TestCheckNewEntriesMovedAndGone(t *testing.T) {
+	moved := model.Feed{ID: 1, URL: "https://example.com/moved"}
+	merged := model.Feed{ID: 2, URL: "https://example.com/merged"}
+	gone := model.Feed{ID: 3, URL: "https://example.com/gone"}
+	notFound := model.Feed{ID: 4, URL: "https://example.com/not-found", ConsecutiveNotFound: 2}
+	disabled := model.Feed{ID: 5, URL: "https://example.com/disabled"}
+	private := model.Feed{ID: 6, URL: "https://example.com/private", Options: model.FetchOptions{Token: "secret"}}
+	privateMoved := model.Feed{ID: 7, URL: "https://example.com/private-moved", Options: model.FetchOptions{Headers: map[string]string{"X-Api-Key": "secret"}}}
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "123", FeedID: 1, Feed: moved},
+		{ID: 2, ChannelID: "123", FeedID: 2, Feed: merged},
+		{ID: 3, ChannelID: "123", FeedID: 3, Feed: gone},
+		{ID: 4, ChannelID: "456", FeedID: 4, Feed: notFound},
+		{ID: 5, ChannelID: "456", FeedID: 5, Feed: disabled, Status: model.SubscriptionDisabled},
+		{ID: 6, ChannelID: "456", FeedID: 6, Feed: private},
+		{ID: 7, ChannelID: "456", FeedID: 7, Feed: privateMoved},
+	}
+	m := mockMovingRss{
+		results: map[string]repository.FetchResult{
+			moved.URL:        {MovedTo: "https://EXAMPLE.com/new"},
+			merged.URL:       {MovedTo: "https://example.com/existing"},
+			private.URL:      {MovedTo: "https://other.example.com/private"},
+			privateMoved.URL: {MovedTo: "https://example.com/private-new"},
+		},
+		errs: map[string]error{
+			gone.URL:     gofeed.HTTPError{StatusCode: 410, Status: "410 Gone"},
+			notFound.URL: gofeed.HTTPError{StatusCode: 404, Status: "404 Not Found"},
+			disabled.URL: gofeed.HTTPError{StatusCode: 410, Status: "410 Gone"},
+		},
+	}
+	saved, mergedFeeds := []model.Feed{}, [][2]uint{}
+	fr := mockMergingFeedRepository{existing: map[string]model.Feed{"https://example.com/existing": {ID: 9}}, saved: &saved, merged: &mergedFeeds}
+	sr := mockStatusRepository{status: map[uint]string{}}
+	f := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, fr, sr, m, usecase.PollLimits{}, usecase.Schedule{NotFoundThreshold: 3}, usecase.Retention{})
+
+	// test
+	_, notices := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	urls := map[uint]string{}
+	for _, feed := range saved {
+		urls[feed.ID] = feed.URL
+	}
+	// a feed with credentials never moves to another host
+	wantURLs := map[uint]string{1: "https://example.com/new", 3: gone.URL, 4: notFound.URL, 6: private.URL, 7: "https://example.com/private-new"}
+	if diff := cmp.Diff(urls, wantURLs); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	if diff := cmp.Diff(mergedFeeds, [][2]uint{{2, 9}}); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	wantStatus := map[uint]string{3: model.SubscriptionDisabled, 4: model.SubscriptionDisabled}
+	if diff := cmp.Diff(sr.status, wantStatus); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+	wantNotices := []model.Notice{
+		{ChannelID: "123", Content: "RSS feed is gone, so its subscription is disabled: https://example.com/gone\nhttp error: 410 Gone\nUse /unsubscribe to remove it."},
+		{ChannelID: "456", Content: "RSS feed is gone, so its subscription is disabled: https://example.com/not-found\nhttp error: 404 Not Found\nUse /unsubscribe to remove it."},
+	}
+	if diff := cmp.Diff(notices, wantNotices); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestCheckNewEntriesUpdated(t *testing.T) {
+	published := databasetest.Now()
+	items := []*gofeed.Item{{GUID: "1", Link: "https://example.com/entry1", Title: "tilte1", PublishedParsed: &published}}
+	m := mockRss{mockFetch: func() ([]*gofeed.Item, error) { return items, nil }}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/index.xml"})
+	subs := []model.Subscription{{ID: 1, ChannelID: "123", FeedID: feed.ID, Feed: feed, CreatedAt: published.Add(-time.Hour)}}
+
+	// test
+	first, _ := f.CheckNewEntries(context.Background(), subs)
+	// the typo is fixed and the date bumped
+	edited := published.Add(time.Minute)
+	items = []*gofeed.Item{{GUID: "1", Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &edited}}
+	second, _ := f.CheckNewEntries(context.Background(), subs)
+	third, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if len(first) != 1 || first[0].Updated {
+		t.Fatalf("want: a new entry, got: %v", first)
+	}
+	want := []model.RssEntry{{ID: first[0].ID, FeedID: feed.ID, Identity: "guid:1", EntryTitle: "title1", EntryLink: "https://example.com/entry1", PublishedAt: published, ContentHash: usecase.ContentHash("title1", "https://example.com/entry1"), Updated: true}}
+	for i := range second {
+		second[i].CreatedAt, second[i].SeenAt = time.Time{}, time.Time{}
+	}
+	if !cmp.Equal(second, want) {
+		t.Errorf("Diff: %v", cmp.Diff(second, want))
+	}
+	if len(third) != 0 {
+		t.Errorf("want: 0, got: %v", third)
+	}
+	saved := []model.RssEntry{}
+	db.Find(&saved)
+	if len(saved) != 1 || saved[0].EntryTitle != "title1" {
+		t.Errorf("want: the saved entry updated, got: %v", saved)
+	}
+}
+
+func TestCheckNewEntriesQueued(t *testing.T) {
+	published := databasetest.Now()
+	items := []*gofeed.Item{{GUID: "1", Link: "https://example.com/entry1", Title: "tilte1", PublishedParsed: &published}}
+	m := mockRss{mockFetch: func() ([]*gofeed.Item, error) { return items, nil }}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/index.xml"})
+	before := published.Add(-time.Hour)
+	subs := []model.Subscription{
+		{ID: 1, ChannelID: "1", FeedID: feed.ID, Feed: feed, CreatedAt: before},
+		{ID: 2, ChannelID: "2", FeedID: feed.ID, Feed: feed, OnUpdate: model.UpdateRepost, CreatedAt: before},
+		{ID: 3, ChannelID: "3", FeedID: feed.ID, Feed: feed, OnUpdate: model.UpdateIgnore, CreatedAt: before},
+		// subscribed after the entry was published
+		{ID: 4, ChannelID: "4", FeedID: feed.ID, Feed: feed, CreatedAt: published.Add(time.Hour)},
+		{ID: 5, ChannelID: "5", FeedID: feed.ID, Feed: feed, Status: model.SubscriptionDisabled, CreatedAt: before},
+	}
+	type queued struct {
+		SubscriptionID uint
+		Status         string
+		Action         string
+	}
+	load := func() []queued {
+		deliveries := []model.Delivery{}
+		db.Order("subscription_id").Find(&deliveries)
+		res := []queued{}
+		for _, d := range deliveries {
+			res = append(res, queued{d.SubscriptionID, d.Status, d.Action})
+		}
+		return res
+	}
+
+	// test
+	f.CheckNewEntries(context.Background(), subs)
+	first := load()
+	db.Model(&model.Delivery{}).Where("1 = 1").Updates(map[string]any{"status": model.DeliverySent, "message_id": "100"})
+	items = []*gofeed.Item{{GUID: "1", Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &published}}
+	f.CheckNewEntries(context.Background(), subs)
+	second := load()
+
+	// assert
+	want := []queued{
+		{1, model.DeliveryPending, model.DeliveryPost},
+		{2, model.DeliveryPending, model.DeliveryPost},
+		{3, model.DeliveryPending, model.DeliveryPost},
+	}
+	if !cmp.Equal(first, want) {
+		t.Errorf("Diff: %v", cmp.Diff(first, want))
+	}
+	want = []queued{
+		{1, model.DeliveryPending, model.DeliveryEdit},
+		{2, model.DeliveryPending, model.DeliveryPost},
+		{3, model.DeliverySent, model.DeliveryPost},
+	}
+	if !cmp.Equal(second, want) {
+		t.Errorf("Diff: %v", cmp.Diff(second, want))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	published := databasetest.Now()
+	items := []*gofeed.Item{
+		{GUID: "1", Title: "title1", PublishedParsed: &published},
+		{GUID: "2", Title: "title2", PublishedParsed: &published},
+	}
+	m := mockRss{mockFetch: func() ([]*gofeed.Item, error) { return items, nil }}
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	f := usecase.NewRssEntriesUsecase(rr, fr, persistence.NewSubscriptionPersistence(db), m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{MaxAge: time.Nanosecond, MaxEntries: 1, MaxAttempts: 10})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/index.xml"})
+	subs := []model.Subscription{{ID: 1, ChannelID: "123", FeedID: feed.ID, Feed: feed, CreatedAt: published.Add(-time.Hour)}}
+	f.CheckNewEntries(context.Background(), subs)
+	db.Model(&model.Delivery{}).Where("1 = 1").Update("status", model.DeliverySent)
+
+	// test
+	// both entries are live, however old
+	f.Prune()
+	kept := []model.RssEntry{}
+	db.Find(&kept)
+	// the first entry leaves the feed
+	items = items[1:]
+	again, _ := f.CheckNewEntries(context.Background(), subs)
+	f.Prune()
+	left := []model.RssEntry{}
+	db.Find(&left)
+	again2, _ := f.CheckNewEntries(context.Background(), subs)
+
+	// assert
+	if len(kept) != 2 {
+		t.Errorf("want: 2 live entries kept, got: %v", kept)
+	}
+	if len(left) != 1 || left[0].Identity != "guid:2" {
+		t.Errorf("want: the live entry kept, got: %v", left)
+	}
+	if len(again) != 0 || len(again2) != 0 {
+		t.Errorf("want: nothing posted again, got: %v, %v", again, again2)
+	}
+}
+
 func TestDiff(t *testing.T) {
 	type args struct {
 		oldEntries []model.RssEntry
@@ -180,31 +836,47 @@ func TestDiff(t *testing.T) {
 		{
 			name: "new",
 			args: args{
-				oldEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}},
-				newEntries: []model.RssEntry{{EntryLink: "https://new.example.com"}, {EntryLink: "https://old.example.com"}},
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:new"}, {FeedID: 1, Identity: "guid:old"}},
 			},
-			want: []model.RssEntry{{EntryLink: "https://new.example.com"}},
+			want: []model.RssEntry{{FeedID: 1, Identity: "guid:new"}},
 		},
 		{
 			name: "same",
 			args: args{
-				oldEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}},
-				newEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}},
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old"}},
 			},
 			want: []model.RssEntry{},
 		},
 		{
-			name: "old",
+			name: "same identity in another feed",
+			args: args{
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old"}},
+				newEntries: []model.RssEntry{{FeedID: 2, Identity: "guid:old"}},
+			},
+			want: []model.RssEntry{{FeedID: 2, Identity: "guid:old"}},
+		},
+		{
+			name: "same link with another identity",
 			args: args{
-				oldEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}, {EntryLink: "https://old2.example.com"}},
-				newEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}},
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old", EntryLink: "https://example.com/"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:new", EntryLink: "https://example.com/"}},
+			},
+			want: []model.RssEntry{{FeedID: 1, Identity: "guid:new", EntryLink: "https://example.com/"}},
+		},
+		{
+			name: "saved before identities",
+			args: args{
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "legacy:https://old.example.com", EntryLink: "https://old.example.com"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old", EntryLink: "https://old.example.com"}},
 			},
 			want: []model.RssEntry{},
 		},
 		{
 			name: "empty",
 			args: args{
-				oldEntries: []model.RssEntry{{EntryLink: "https://old.example.com"}},
+				oldEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:old"}},
 				newEntries: []model.RssEntry{},
 			},
 			want: []model.RssEntry{},
@@ -220,6 +892,60 @@ func TestDiff(t *testing.T) {
 	}
 }
 
+func TestChanged(t *testing.T) {
+	type args struct {
+		oldEntries []model.RssEntry
+		newEntries []model.RssEntry
+	}
+	published := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		args args
+		want []model.RssEntry
+	}{
+		{
+			name: "changed",
+			args: args{
+				oldEntries: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "old", ContentHash: "old", PublishedAt: published}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:1", EntryTitle: "new", ContentHash: "new", PublishedAt: published.Add(time.Hour)}},
+			},
+			want: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1", EntryTitle: "new", ContentHash: "new", PublishedAt: published, Updated: true}},
+		},
+		{
+			name: "same",
+			args: args{
+				oldEntries: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1", ContentHash: "same"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:1", ContentHash: "same"}},
+			},
+			want: []model.RssEntry{},
+		},
+		{
+			name: "new",
+			args: args{
+				oldEntries: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1", ContentHash: "old"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:2", ContentHash: "new"}},
+			},
+			want: []model.RssEntry{},
+		},
+		{
+			name: "saved before content hashes",
+			args: args{
+				oldEntries: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1"}},
+				newEntries: []model.RssEntry{{FeedID: 1, Identity: "guid:1", ContentHash: "new"}},
+			},
+			want: []model.RssEntry{{ID: 1, FeedID: 1, Identity: "guid:1", ContentHash: "new"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := usecase.Changed(tt.args.newEntries, tt.args.oldEntries)
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Diff: %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
 func TestUnique(t *testing.T) {
 	type args struct {
 		entries []model.RssEntry
@@ -232,9 +958,9 @@ func TestUnique(t *testing.T) {
 		{
 			name: "unique",
 			args: args{
-				entries: []model.RssEntry{{EntryLink: "https://example.com"}, {EntryLink: "https://example.com"}},
+				entries: []model.RssEntry{{FeedID: 1, Identity: "guid:1"}, {FeedID: 1, Identity: "guid:1"}, {FeedID: 2, Identity: "guid:1"}},
 			},
-			want: []model.RssEntry{{EntryLink: "https://example.com"}},
+			want: []model.RssEntry{{FeedID: 1, Identity: "guid:1"}, {FeedID: 2, Identity: "guid:1"}},
 		},
 		{
 			name: "empty",
@@ -253,3 +979,94 @@ func TestUnique(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		args *gofeed.Item
+		want string
+	}{
+		{name: "GUID", args: &gofeed.Item{GUID: " tag:example.com,2024:1 ", Link: "https://example.com/1"}, want: "guid:tag:example.com,2024:1"},
+		{name: "link", args: &gofeed.Item{Link: "https://example.com/1"}, want: "link:https://example.com/1"},
+		{name: "link without tracking parameters", args: &gofeed.Item{Link: "https://example.com/1?id=2&utm_source=rss&fbclid=x"}, want: "link:https://example.com/1?id=2"},
+		{name: "content", args: &gofeed.Item{Title: "title", Description: "description"}, want: "hash:198f8e2ea073b1eeb265959f632d9259bb19fef47ad88c29b0a4f75122a1b99e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := usecase.Identity(tt.args)
+			if got != tt.want {
+				t.Errorf("want: %s, got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	published := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		item string
+		want model.RssEntry
+	}{
+		{
+			name: "title and link",
+			item: `<item><guid>1</guid><title>title1</title><link>https://example.com/1</link></item>`,
+			want: model.RssEntry{FeedID: 1, Identity: "guid:1", EntryTitle: "title1", EntryLink: "https://example.com/1", PublishedAt: published},
+		},
+		{
+			name: "content",
+			item: `<item><guid>1</guid><title>title1</title><description>&lt;p&gt;summary&lt;/p&gt;</description>
+				<content:encoded><![CDATA[<p>content</p>]]></content:encoded>
+				<author>author@example.com (author)</author><category>go</category><category>rss</category>
+				<enclosure url="https://example.com/1.mp3" type="audio/mpeg" length="1024"/></item>`,
+			want: model.RssEntry{
+				FeedID: 1, Identity: "guid:1", EntryTitle: "title1", PublishedAt: published,
+				Description: "<p>summary</p>", Content: "<p>content</p>",
+				Authors:    []model.Author{{Name: "author", Email: "author@example.com"}},
+				Categories: []string{"go", "rss"},
+				Enclosures: []model.Enclosure{{URL: "https://example.com/1.mp3", Type: "audio/mpeg", Length: 1024}},
+			},
+		},
+		{
+			name: "media",
+			item: `<item><guid>1</guid><media:group>
+				<media:content url="https://example.com/1.mp4" type="video/mp4" medium="video" width="1280" height="720">
+				<media:thumbnail url="https://example.com/1.jpg" width="640" height="360"/></media:content>
+				</media:group></item>`,
+			want: model.RssEntry{
+				FeedID: 1, Identity: "guid:1", PublishedAt: published, ImageURL: "https://example.com/1.jpg",
+				Media: []model.Media{
+					{URL: "https://example.com/1.mp4", Type: "video/mp4", Medium: "video", Width: 1280, Height: 720},
+					{URL: "https://example.com/1.jpg", Width: 640, Height: 360, Thumbnail: true},
+				},
+			},
+		},
+		{
+			name: "image enclosure with an invalid length",
+			item: `<item><guid>1</guid><enclosure url="https://example.com/1.png" type="image/png" length="unknown"/></item>`,
+			want: model.RssEntry{
+				FeedID: 1, Identity: "guid:1", PublishedAt: published, ImageURL: "https://example.com/1.png",
+				Enclosures: []model.Enclosure{{URL: "https://example.com/1.png", Type: "image/png"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// prepare
+			feed, err := gofeed.NewParser().ParseString(`<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/"
+				xmlns:media="http://search.yahoo.com/mrss/"><channel>` + tt.item + `</channel></rss>`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// test
+			got := usecase.NewEntry(1, feed.Items[0], published)
+
+			// assert
+			got.ContentHash = ""
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("Diff: %v", diff)
+			}
+		})
+	}
+}
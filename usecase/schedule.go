@@ -0,0 +1,123 @@
+package usecase
+
+import (
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+)
+
+// jitterRatio spreads the polls of feeds sharing an interval over a tenth of it
+const jitterRatio = 0.1
+
+// maxBackoffDoublings caps the backoff of a failing feed when there is no MaxInterval
+const maxBackoffDoublings = 10
+
+// historySize is how many of the latest entries of a feed its cadence is learned from
+const historySize = 20
+
+// Schedule decides when a feed is polled next.
+type Schedule struct {
+	// Interval is used for feeds whose subscriptions do not ask for one and which have too little history
+	Interval time.Duration
+	// MinInterval is the shortest interval a subscription may ask for, and the lower bound of the learned interval
+	MinInterval time.Duration
+	// MaxInterval is the upper bound of the learned interval, the backoff and the publisher's hints, zero for none
+	MaxInterval time.Duration
+	// FailureThreshold is how many consecutive failures mark the subscriptions of a feed broken, zero for never
+	FailureThreshold int
+	// NotFoundThreshold is how many 404 responses in a row disable the subscriptions of a feed, zero for never
+	NotFoundThreshold int
+}
+
+// pollHints are the publisher's hints of a single response
+type pollHints struct {
+	maxAge     time.Duration
+	retryAfter time.Duration
+}
+
+// next returns when a feed checked at now is due again.
+// A zero interval means the interval is learned from published, the latest publication times of the feed.
+// A failing feed backs off exponentially, and a feed pushed by its hub is polled every MaxInterval. The publisher's hints only ever slow polling down,
+// and the due time is moved out of the skipped hours and days.
+func (s Schedule) next(now time.Time, feed model.Feed, interval time.Duration, hints pollHints, published []time.Time) time.Time {
+	if interval <= 0 {
+		interval = s.adaptive(now, published)
+	}
+	interval = s.backoff(interval, feed.ConsecutiveFailures)
+	// a feed pushed by its hub is only polled as a fallback
+	if feed.WebSubExpiresAt.After(now) {
+		interval = max(interval, s.MaxInterval)
+	}
+	for _, hint := range []time.Duration{feed.TTL, hints.maxAge, hints.retryAfter} {
+		if s.MaxInterval > 0 {
+			hint = min(hint, s.MaxInterval)
+		}
+		interval = max(interval, hint)
+	}
+	jitter := time.Duration(rand.Int64N(int64(float64(interval)*jitterRatio) + 1))
+	return skip(now.Add(interval+jitter), feed.SkipHours, feed.SkipDays)
+}
+
+// adaptive polls a feed twice per typical gap between its entries,
+// where a feed quiet for longer than that gap backs off with the time since its latest entry.
+func (s Schedule) adaptive(now time.Time, published []time.Time) time.Duration {
+	if len(published) < 3 {
+		return s.Interval
+	}
+	sorted := slices.Clone(published)
+	slices.SortFunc(sorted, func(a, b time.Time) int { return b.Compare(a) })
+	gaps := make([]time.Duration, 0, len(sorted)-1)
+	for i := 1; i < len(sorted); i++ {
+		gaps = append(gaps, sorted[i-1].Sub(sorted[i]))
+	}
+	slices.Sort(gaps)
+	gap := max(gaps[len(gaps)/2], now.Sub(sorted[0]))
+
+	interval := max(gap/2, s.MinInterval)
+	if s.MaxInterval > 0 {
+		interval = min(interval, s.MaxInterval)
+	}
+	return interval
+}
+
+// backoff doubles interval for every consecutive failure after the first one, up to MaxInterval.
+// An interval already longer than MaxInterval is kept as is.
+func (s Schedule) backoff(interval time.Duration, failures int) time.Duration {
+	backoff := interval
+	for range min(failures-1, maxBackoffDoublings) {
+		backoff *= 2
+	}
+	if s.MaxInterval > 0 {
+		backoff = min(backoff, max(interval, s.MaxInterval))
+	}
+	return backoff
+}
+
+// skip moves t to the start of the next hour outside of the skipped hours and days, which are in GMT.
+// It gives up and returns t when every hour of the week is skipped.
+func skip(t time.Time, hours []int, days []time.Weekday) time.Time {
+	if len(hours) == 0 && len(days) == 0 {
+		return t
+	}
+	next := t.UTC()
+	for range 7 * 24 {
+		if !slices.Contains(hours, next.Hour()) && !slices.Contains(days, next.Weekday()) {
+			return next.In(t.Location())
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return t
+}
+
+// dueFeeds keeps the feeds whose next due time has passed, including feeds never checked.
+func dueFeeds(groups []feedGroup, now time.Time) []feedGroup {
+	due := []feedGroup{}
+	for _, g := range groups {
+		if !g.feed.NextDueAt.After(now) {
+			due = append(due, g)
+		}
+	}
+	return due
+}
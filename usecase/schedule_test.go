@@ -0,0 +1,134 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/usecase"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Monday
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	every := func(gap time.Duration, latest time.Time) []time.Time {
+		published := []time.Time{}
+		for i := range 5 {
+			published = append(published, latest.Add(-time.Duration(i)*gap))
+		}
+		return published
+	}
+	type args struct {
+		feed       model.Feed
+		interval   time.Duration
+		maxAge     time.Duration
+		retryAfter time.Duration
+		published  []time.Time
+	}
+	tests := []struct {
+		name string
+		args args
+		// want is the interval before jitter
+		want time.Duration
+	}{
+		{
+			name: "default without history",
+			args: args{},
+			want: 10 * time.Minute,
+		},
+		{
+			name: "subscription interval",
+			args: args{interval: time.Hour, published: every(time.Minute, now)},
+			want: time.Hour,
+		},
+		{
+			name: "learned from history",
+			args: args{published: every(30*time.Minute, now)},
+			want: 15 * time.Minute,
+		},
+		{
+			name: "quiet feed backs off",
+			args: args{published: every(30*time.Minute, now.Add(-4*time.Hour))},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "learned clamped to min",
+			args: args{published: every(30*time.Second, now)},
+			want: time.Minute,
+		},
+		{
+			name: "learned clamped to max",
+			args: args{published: every(30*24*time.Hour, now)},
+			want: 24 * time.Hour,
+		},
+		{
+			name: "first failure keeps the interval",
+			args: args{feed: model.Feed{ConsecutiveFailures: 1}},
+			want: 10 * time.Minute,
+		},
+		{
+			name: "failures back off",
+			args: args{feed: model.Feed{ConsecutiveFailures: 4}},
+			want: 80 * time.Minute,
+		},
+		{
+			name: "backoff capped at max",
+			args: args{feed: model.Feed{ConsecutiveFailures: 30}},
+			want: 24 * time.Hour,
+		},
+		{
+			name: "ttl slows down",
+			args: args{feed: model.Feed{TTL: time.Hour}},
+			want: time.Hour,
+		},
+		{
+			name: "max-age slows down",
+			args: args{maxAge: 30 * time.Minute},
+			want: 30 * time.Minute,
+		},
+		{
+			name: "retry after capped at max",
+			args: args{retryAfter: 72 * time.Hour},
+			want: 24 * time.Hour,
+		},
+		{
+			name: "hints do not speed up",
+			args: args{interval: time.Hour, maxAge: time.Minute},
+			want: time.Hour,
+		},
+		{
+			name: "pushed feed polled as a fallback",
+			args: args{feed: model.Feed{WebSubExpiresAt: now.Add(time.Hour)}, published: every(30*time.Minute, now)},
+			want: 24 * time.Hour,
+		},
+		{
+			name: "expired push lease",
+			args: args{feed: model.Feed{WebSubExpiresAt: now.Add(-time.Hour)}},
+			want: 10 * time.Minute,
+		},
+		{
+			name: "skip hours",
+			args: args{feed: model.Feed{SkipHours: []int{10, 11}}},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "skip days",
+			args: args{feed: model.Feed{SkipDays: []time.Weekday{time.Monday}}},
+			want: 14 * time.Hour,
+		},
+	}
+
+	s := usecase.Schedule{Interval: 10 * time.Minute, MinInterval: time.Minute, MaxInterval: 24 * time.Hour}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// test
+			got := s.Next(now, tt.args.feed, tt.args.interval, tt.args.maxAge, tt.args.retryAfter, tt.args.published)
+
+			// assert
+			from, to := now.Add(tt.want), now.Add(tt.want+tt.want/10)
+			if got.Before(from) || got.After(to) {
+				t.Errorf("want between %v and %v, got: %v", from, to, got)
+			}
+		})
+	}
+}
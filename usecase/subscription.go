@@ -1,29 +1,135 @@
 package usecase
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/dev-shimada/discord-rss-bot/domain/model"
 	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/mmcdole/gofeed"
 	"golang.org/x/exp/slog"
 )
 
+// hubSubscriber subscribes to the WebSub hub of a feed
+type hubSubscriber interface {
+	Subscribe(ctx context.Context, feed model.Feed) error
+}
+
 type SubscriptionUsecase struct {
-	sr repository.SubscriptionRepository
+	sr         repository.SubscriptionRepository
+	fr         repository.FeedRepository
+	fd         repository.FeedDiscoverer
+	resolver   repository.SourceResolver
+	rssFetcher repository.RssFetcher
+	hubs       hubSubscriber
+	schedule   Schedule
+}
+
+func NewSubscriptionUsecase(sr repository.SubscriptionRepository, fr repository.FeedRepository, fd repository.FeedDiscoverer, resolver repository.SourceResolver, rss repository.RssFetcher, hubs hubSubscriber, schedule Schedule) SubscriptionUsecase {
+	return SubscriptionUsecase{sr: sr, fr: fr, fd: fd, resolver: resolver, rssFetcher: rss, hubs: hubs, schedule: schedule}
+}
+
+// Resolve turns a shorthand given by a user, such as github:owner/repo/releases, into a feed URL.
+// Any other source is returned unchanged.
+func (s SubscriptionUsecase) Resolve(ctx context.Context, source string) (string, error) {
+	return s.resolver.Resolve(ctx, source)
 }
 
-func NewSubscriptionUsecase(sr repository.SubscriptionRepository) SubscriptionUsecase {
-	return SubscriptionUsecase{sr: sr}
+// Discover resolves a URL given by a user into the feeds it points to.
+func (s SubscriptionUsecase) Discover(ctx context.Context, url string) ([]repository.DiscoveredFeed, error) {
+	return s.fd.Discover(ctx, url)
 }
 
-func (s SubscriptionUsecase) Create(sub model.Subscription) string {
-	err := s.sr.Create(sub)
+// Create validates the feed by fetching it before saving, and rejects a feed already subscribed in the channel.
+// The feed is given by sub.Feed.URL, along with sub.Feed.Selector for a page to scrape and sub.Feed.Options
+// to customize its requests, and shared with the other subscriptions to the same URL, selector and options.
+// Credentials given in the URL are moved to the options, so they are stored sealed.
+func (s SubscriptionUsecase) Create(ctx context.Context, sub model.Subscription) string {
+	rssURL, options, err := credentials(sub.Feed.URL, sub.Feed.Options)
+	if err != nil {
+		return "Invalid URL."
+	}
+	rssURL, err = canonicalURL(rssURL)
+	if err != nil {
+		return "Invalid URL."
+	}
+	if !validProxy(options.Proxy) {
+		return "Invalid proxy."
+	}
+	if sub.Interval < 0 || sub.Interval > 0 && sub.Interval < s.schedule.MinInterval {
+		return fmt.Sprintf("Interval must be at least %v.", s.schedule.MinInterval)
+	}
+	switch sub.OnUpdate {
+	case "", model.UpdateEdit, model.UpdateRepost, model.UpdateIgnore:
+	default:
+		return "Invalid update mode. Use edit, repost or ignore."
+	}
+
+	source := model.Feed{URL: rssURL, Selector: sub.Feed.Selector, Options: options}
+	feed, err := s.fr.FindBySource(source)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		feed = source
+	case err != nil:
+		slog.Error(fmt.Sprintf("Failed to find feed: %v", err))
+		return "Failed to subscribe to RSS feed."
+	default:
+		subs, err := s.sr.FindByModel(model.Subscription{ChannelID: sub.ChannelID, FeedID: feed.ID})
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to find subscriptions: %v", err))
+			return "Failed to subscribe to RSS feed."
+		}
+		if len(subs) > 0 {
+			return fmt.Sprintf("Already subscribed to RSS feed: %s", rssURL)
+		}
+	}
+
+	result, err := s.rssFetcher.Fetch(ctx, source)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to fetch RSS %s: %v", rssURL, err))
+		return fmt.Sprintf("Failed to fetch RSS feed: %s", rssURL)
+	}
+	if feed.ID == 0 {
+		feed.Title = result.Title
+		feed.SiteLink = result.SiteLink
+		feed.IconURL = result.IconURL
+		feed.HubURL = result.HubURL
+		if result.HubURL != "" {
+			feed.TopicURL = cmp.Or(result.SelfURL, rssURL)
+		}
+		feed, err = s.fr.FindOrCreate(feed)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to create feed: %v", err))
+			return "Failed to subscribe to RSS feed."
+		}
+	}
+
+	err = s.sr.Create(model.Subscription{ChannelID: sub.ChannelID, FeedID: feed.ID, Interval: sub.Interval, OnUpdate: sub.OnUpdate})
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		return fmt.Sprintf("Already subscribed to RSS feed: %s", rssURL)
+	}
 	if err != nil {
 		slog.Error(fmt.Sprintf("Failed to subscribe: %v", err))
 		return "Failed to subscribe to RSS feed."
 	}
+	// get new entries pushed right away, polling covers them until the hub verifies the subscription
+	if s.hubs != nil && feed.HubURL != "" && feed.WebSubExpiresAt.Before(time.Now()) {
+		if err := s.hubs.Subscribe(ctx, feed); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to subscribe to the hub of RSS %s: %v", rssURL, err))
+		}
+	}
 
-	return "Successfully subscribed to RSS feed."
+	msg := fmt.Sprintf("Successfully subscribed to RSS feed: %s\n%s", result.Title, rssURL)
+	if latest := latestItem(result.Items); latest != nil {
+		msg += fmt.Sprintf("\nLatest entry: %s\n%s", latest.Title, latest.Link)
+	}
+	return msg
 }
 
 func (s SubscriptionUsecase) List(sub model.Subscription) ([]model.Subscription, error) {
@@ -37,3 +143,77 @@ func (s SubscriptionUsecase) Delete(sub model.Subscription) error {
 func (s SubscriptionUsecase) FindAll() ([]model.Subscription, error) {
 	return s.sr.FindAll()
 }
+
+// canonicalURL normalizes the parts of a URL that do not change the resource,
+// so the same feed is stored the same way regardless of how it was typed.
+func canonicalURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("unsupported URL: %s", raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	switch {
+	case u.Scheme == "http" && port == "80", u.Scheme == "https" && port == "443", port == "":
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	default:
+		u.Host = net.JoinHostPort(host, port)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String(), nil
+}
+
+// credentials moves the username and password of a URL into options, unless options carry credentials already.
+func credentials(raw string, options model.FetchOptions) (string, model.FetchOptions, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.User == nil {
+		return raw, options, err
+	}
+	if options.Username == "" && options.Password == "" && options.Token == "" {
+		options.Username = u.User.Username()
+		options.Password, _ = u.User.Password()
+	}
+	u.User = nil
+	return u.String(), options, nil
+}
+
+// validProxy reports whether proxy is empty or a URL of a proxy the fetcher can use.
+func validProxy(proxy string) bool {
+	if proxy == "" {
+		return true
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return true
+	}
+	return false
+}
+
+// latestItem returns the most recently published item, or the first one when no item has a date.
+func latestItem(items []*gofeed.Item) *gofeed.Item {
+	var latest *gofeed.Item
+	for _, item := range items {
+		if latest == nil {
+			latest = item
+			continue
+		}
+		if item.PublishedParsed != nil && (latest.PublishedParsed == nil || item.PublishedParsed.After(*latest.PublishedParsed)) {
+			latest = item
+		}
+	}
+	return latest
+}
@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+)
+
+// webSubRetry is how long a subscription request is left to be verified before it is sent again
+const webSubRetry = time.Hour
+
+// WebSub configures the subscriptions to WebSub hubs.
+type WebSub struct {
+	// CallbackURL is the public URL the hubs call back, followed by the feed ID. WebSub is disabled when empty.
+	CallbackURL string
+	// Lease is the lease asked to the hubs, which may grant another one
+	Lease time.Duration
+}
+
+type WebSubUsecase struct {
+	fr     repository.FeedRepository
+	sr     repository.SubscriptionRepository
+	hub    repository.HubClient
+	parser repository.FeedParser
+	ru     RssEntriesUsecase
+	config WebSub
+}
+
+func NewWebSubUsecase(fr repository.FeedRepository, sr repository.SubscriptionRepository, hub repository.HubClient, parser repository.FeedParser, ru RssEntriesUsecase, config WebSub) WebSubUsecase {
+	return WebSubUsecase{fr: fr, sr: sr, hub: hub, parser: parser, ru: ru, config: config}
+}
+
+// Subscribe asks the hub of feed to push it. It does nothing when WebSub is disabled or the feed has no hub.
+func (w WebSubUsecase) Subscribe(ctx context.Context, feed model.Feed) error {
+	if w.config.CallbackURL == "" || feed.HubURL == "" {
+		return nil
+	}
+	if feed.WebSubSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		feed.WebSubSecret = hex.EncodeToString(secret)
+	}
+	// some hubs verify the intent before answering, so the request is saved first
+	feed.WebSubRequestedAt = time.Now()
+	if err := w.fr.UpdateWebSub(feed); err != nil {
+		return err
+	}
+	return w.hub.Subscribe(ctx, feed.HubURL, repository.HubRequest{
+		Mode:     "subscribe",
+		Topic:    feed.TopicURL,
+		Callback: strings.TrimSuffix(w.config.CallbackURL, "/") + "/" + strconv.FormatUint(uint64(feed.ID), 10),
+		Secret:   feed.WebSubSecret,
+		Lease:    w.config.Lease,
+	})
+}
+
+// Renew subscribes to the hubs of the feeds not pushed yet, and renews the leases about to expire.
+func (w WebSubUsecase) Renew(ctx context.Context, s []model.Subscription) {
+	if w.config.CallbackURL == "" {
+		return
+	}
+	now := time.Now()
+	// renew halfway through the lease, but at least a day before it expires
+	margin := max(w.config.Lease/2, 24*time.Hour)
+	for _, g := range groupByFeed(enabled(s)) {
+		feed := g.feed
+		if feed.HubURL == "" || feed.WebSubExpiresAt.After(now.Add(margin)) || feed.WebSubRequestedAt.After(now.Add(-webSubRetry)) {
+			continue
+		}
+		if err := w.Subscribe(ctx, feed); err != nil {
+			slog.Warn(fmt.Sprintf("failed to subscribe to the hub of RSS %s: %v", feed.URL, err))
+		}
+	}
+}
+
+// Verify checks the intent of a hub calling back for the feed of feedID, and records the lease it granted,
+// never longer than the lease asked. It returns repository.ErrNotFound when the request was not sent by this bot,
+// or not within webSubRetry, so that a forged call back neither extends the lease nor ends it.
+func (w WebSubUsecase) Verify(feedID uint, mode, topic string, lease time.Duration) error {
+	feed, err := w.fr.Find(feedID)
+	if err != nil {
+		return err
+	}
+	if feed.TopicURL == "" || topic != feed.TopicURL {
+		return repository.ErrNotFound
+	}
+	now := time.Now()
+	if feed.WebSubRequestedAt.IsZero() || now.Sub(feed.WebSubRequestedAt) > webSubRetry {
+		return repository.ErrNotFound
+	}
+	switch mode {
+	case "subscribe":
+		if feed.WebSubSecret == "" {
+			return repository.ErrNotFound
+		}
+		if lease <= 0 || w.config.Lease > 0 && lease > w.config.Lease {
+			lease = w.config.Lease
+		}
+		feed.WebSubExpiresAt = now.Add(lease)
+	case "unsubscribe":
+		// only confirm when nobody subscribes to the feed any more
+		subs, err := w.sr.FindByModel(model.Subscription{FeedID: feedID})
+		if err != nil {
+			return err
+		}
+		if len(enabled(subs)) > 0 {
+			return repository.ErrNotFound
+		}
+		feed.WebSubExpiresAt = time.Time{}
+	case "denied":
+		slog.Warn(fmt.Sprintf("hub of RSS %s denied the subscription", feed.URL))
+		feed.WebSubExpiresAt = time.Time{}
+	default:
+		return repository.ErrNotFound
+	}
+	return w.fr.UpdateWebSub(feed)
+}
+
+// Receive processes the content pushed for the feed of feedID through the same path as a poll,
+// and returns the entries not seen before along with the notices to deliver.
+// The fetch state, the schedule and the health of the feed are left to the polls, as the feed itself is not fetched.
+func (w WebSubUsecase) Receive(ctx context.Context, feedID uint, body []byte, signature string) ([]model.RssEntry, []model.Notice, error) {
+	feed, err := w.fr.Find(feedID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if feed.WebSubSecret == "" || !validSignature(feed.WebSubSecret, body, signature) {
+		return nil, nil, repository.ErrInvalidSignature
+	}
+	result, err := w.parser.ParseFeed(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.ru.mu.Lock()
+	defer w.ru.mu.Unlock()
+	// loaded again under the lock, so that it is not behind a poll saved meanwhile
+	feed, err = w.fr.Find(feedID)
+	if err != nil {
+		return nil, nil, err
+	}
+	subs, err := w.sr.FindByModel(model.Subscription{FeedID: feedID})
+	if err != nil {
+		return nil, nil, err
+	}
+	subs = enabled(subs)
+	if len(subs) == 0 {
+		return []model.RssEntry{}, nil, nil
+	}
+
+	// the pushed document may only hold the updated entries, so keep what it lacks
+	feed.Title = cmp.Or(result.Title, feed.Title)
+	feed.SiteLink = cmp.Or(result.SiteLink, feed.SiteLink)
+	feed.IconURL = cmp.Or(result.IconURL, feed.IconURL)
+	feed.Repairs = result.Repairs
+	groups := groupByFeed(subs)
+	entries, notices := w.ru.processLocked(subs, groups, []fetched{{items: result.Items, feed: feed, checked: true, pushed: true}}, time.Now())
+	return entries, notices, nil
+}
+
+// validSignature checks the X-Hub-Signature of body, given as method=hex.
+func validSignature(secret string, body []byte, signature string) bool {
+	method, sum, _ := strings.Cut(signature, "=")
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	want, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
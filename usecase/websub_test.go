@@ -0,0 +1,365 @@
+package usecase_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dev-shimada/discord-rss-bot/domain/model"
+	"github.com/dev-shimada/discord-rss-bot/domain/repository"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/database/databasetest"
+	"github.com/dev-shimada/discord-rss-bot/infrastructure/persistence"
+	"github.com/dev-shimada/discord-rss-bot/usecase"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmcdole/gofeed"
+)
+
+// mockWebSubFeedRepository finds the feeds by ID and records the WebSub state updates
+type mockWebSubFeedRepository struct {
+	mockFeedRepository
+	feeds   map[uint]model.Feed
+	updated *[]model.Feed
+}
+
+func (r mockWebSubFeedRepository) Find(id uint) (model.Feed, error) {
+	feed, ok := r.feeds[id]
+	if !ok {
+		return model.Feed{}, repository.ErrNotFound
+	}
+	return feed, nil
+}
+
+func (r mockWebSubFeedRepository) UpdateWebSub(feed model.Feed) error {
+	*r.updated = append(*r.updated, feed)
+	return nil
+}
+
+// mockHub records the requests sent to the hubs
+type mockHub struct {
+	requests *[]repository.HubRequest
+}
+
+func (m mockHub) Subscribe(_ context.Context, _ string, req repository.HubRequest) error {
+	*m.requests = append(*m.requests, req)
+	return nil
+}
+
+// mockParser is a mock of FeedParser interface
+type mockParser struct {
+	items []*gofeed.Item
+}
+
+func (m mockParser) ParseFeed(_ []byte) (repository.FetchResult, error) {
+	return repository.FetchResult{Items: m.items}, nil
+}
+
+func TestWebSubRenew(t *testing.T) {
+	now := time.Now()
+	unleased := model.Feed{ID: 1, URL: "https://example.com/1", HubURL: "https://hub.example.com/", TopicURL: "https://example.com/1"}
+	expiring := model.Feed{ID: 2, URL: "https://example.com/2", HubURL: "https://hub.example.com/", TopicURL: "https://example.com/2", WebSubSecret: "secret", WebSubExpiresAt: now.Add(time.Hour)}
+	leased := model.Feed{ID: 3, URL: "https://example.com/3", HubURL: "https://hub.example.com/", WebSubExpiresAt: now.Add(9 * 24 * time.Hour)}
+	requested := model.Feed{ID: 4, URL: "https://example.com/4", HubURL: "https://hub.example.com/", WebSubRequestedAt: now.Add(-time.Minute)}
+	noHub := model.Feed{ID: 5, URL: "https://example.com/5"}
+	disabled := model.Feed{ID: 6, URL: "https://example.com/6", HubURL: "https://hub.example.com/"}
+	subs := []model.Subscription{
+		{ID: 1, FeedID: 1, Feed: unleased},
+		{ID: 2, FeedID: 2, Feed: expiring},
+		{ID: 3, FeedID: 3, Feed: leased},
+		{ID: 4, FeedID: 4, Feed: requested},
+		{ID: 5, FeedID: 5, Feed: noHub},
+		{ID: 6, FeedID: 6, Feed: disabled, Status: model.SubscriptionDisabled},
+	}
+
+	tests := []struct {
+		name        string
+		callbackURL string
+		want        []model.Feed
+	}{
+		{name: "disabled", callbackURL: "", want: []model.Feed{}},
+		{name: "unleased and expiring", callbackURL: "https://bot.example.com/websub/", want: []model.Feed{unleased, expiring}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			updated := []model.Feed{}
+			requests := []repository.HubRequest{}
+			fr := mockWebSubFeedRepository{updated: &updated}
+			w := usecase.NewWebSubUsecase(fr, mockSubscription{}, mockHub{requests: &requests}, mockParser{}, usecase.RssEntriesUsecase{}, usecase.WebSub{CallbackURL: tt.callbackURL, Lease: 10 * 24 * time.Hour})
+
+			// test
+			w.Renew(context.Background(), subs)
+
+			// assert
+			if len(requests) != len(tt.want) {
+				t.Fatalf("want: %d requests, got: %v", len(tt.want), requests)
+			}
+			for i, req := range requests {
+				feed := updated[i]
+				if req.Topic != tt.want[i].TopicURL || req.Mode != "subscribe" || req.Lease != 10*24*time.Hour {
+					t.Errorf("want: subscribe %s, got: %v", tt.want[i].TopicURL, req)
+				}
+				if req.Secret == "" || req.Secret != feed.WebSubSecret || feed.WebSubRequestedAt.IsZero() {
+					t.Errorf("want: request saved with its secret, got: %v", feed)
+				}
+				if want := fmt.Sprintf("https://bot.example.com/websub/%d", tt.want[i].ID); req.Callback != want {
+					t.Errorf("want: %s, got: %s", want, req.Callback)
+				}
+			}
+			// an existing secret is kept so that the hub keeps signing with it
+			if len(requests) == 2 && requests[1].Secret != "secret" {
+				t.Errorf("want: secret, got: %s", requests[1].Secret)
+			}
+		})
+	}
+}
+
+func TestWebSubVerify(t *testing.T) {
+	requested := time.Now().Add(-time.Minute)
+	feeds := map[uint]model.Feed{
+		1: {ID: 1, URL: "https://example.com/1", TopicURL: "https://example.com/1", WebSubSecret: "secret", WebSubRequestedAt: requested},
+		2: {ID: 2, URL: "https://example.com/2", TopicURL: "https://example.com/2", WebSubRequestedAt: requested},
+		4: {ID: 4, URL: "https://example.com/4", TopicURL: "https://example.com/4", WebSubSecret: "secret", WebSubRequestedAt: time.Now().Add(-2 * time.Hour)},
+		5: {ID: 5, URL: "https://example.com/5", TopicURL: "https://example.com/5", WebSubSecret: "secret"},
+	}
+
+	tests := []struct {
+		name      string
+		feedID    uint
+		mode      string
+		topic     string
+		lease     time.Duration
+		subs      []model.Subscription
+		wantLease time.Duration
+		wantErr   error
+	}{
+		{name: "subscribe", feedID: 1, mode: "subscribe", topic: "https://example.com/1", lease: time.Hour, wantLease: time.Hour},
+		{name: "subscribe without lease", feedID: 1, mode: "subscribe", topic: "https://example.com/1", wantLease: 24 * time.Hour},
+		{name: "subscribe longer than asked", feedID: 1, mode: "subscribe", topic: "https://example.com/1", lease: 10 * 365 * 24 * time.Hour, wantLease: 24 * time.Hour},
+		{name: "subscribe not requested", feedID: 2, mode: "subscribe", topic: "https://example.com/2", wantErr: repository.ErrNotFound},
+		{name: "subscribe requested too long ago", feedID: 4, mode: "subscribe", topic: "https://example.com/4", wantErr: repository.ErrNotFound},
+		{name: "subscribe never requested", feedID: 5, mode: "subscribe", topic: "https://example.com/5", wantErr: repository.ErrNotFound},
+		{name: "wrong topic", feedID: 1, mode: "subscribe", topic: "https://example.com/2", wantErr: repository.ErrNotFound},
+		{name: "unknown feed", feedID: 3, mode: "subscribe", topic: "https://example.com/3", wantErr: repository.ErrNotFound},
+		{name: "unknown mode", feedID: 1, mode: "publish", topic: "https://example.com/1", wantErr: repository.ErrNotFound},
+		{name: "unsubscribe still subscribed", feedID: 1, mode: "unsubscribe", topic: "https://example.com/1", subs: []model.Subscription{{ID: 1, FeedID: 1}}, wantErr: repository.ErrNotFound},
+		{name: "unsubscribe", feedID: 1, mode: "unsubscribe", topic: "https://example.com/1", subs: []model.Subscription{{ID: 1, FeedID: 1, Status: model.SubscriptionDisabled}}},
+		{name: "unsubscribe never requested", feedID: 5, mode: "unsubscribe", topic: "https://example.com/5", wantErr: repository.ErrNotFound},
+		{name: "denied", feedID: 1, mode: "denied", topic: "https://example.com/1"},
+		{name: "denied never requested", feedID: 5, mode: "denied", topic: "https://example.com/5", wantErr: repository.ErrNotFound},
+		{name: "denied requested too long ago", feedID: 4, mode: "denied", topic: "https://example.com/4", wantErr: repository.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			updated := []model.Feed{}
+			fr := mockWebSubFeedRepository{feeds: feeds, updated: &updated}
+			sr := mockSubscription{mockFindByModel: func() ([]model.Subscription, error) { return tt.subs, nil }}
+			w := usecase.NewWebSubUsecase(fr, sr, mockHub{}, mockParser{}, usecase.RssEntriesUsecase{}, usecase.WebSub{CallbackURL: "https://bot.example.com/websub", Lease: 24 * time.Hour})
+
+			// test
+			now := time.Now()
+			err := w.Verify(tt.feedID, tt.mode, tt.topic, tt.lease)
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				if len(updated) != 0 {
+					t.Errorf("want: no update, got: %v", updated)
+				}
+				return
+			}
+			if len(updated) != 1 {
+				t.Fatalf("want: 1 update, got: %v", updated)
+			}
+			expires := updated[0].WebSubExpiresAt
+			if tt.wantLease == 0 && !expires.IsZero() {
+				t.Errorf("want: zero, got: %v", expires)
+			}
+			if tt.wantLease > 0 && (expires.Before(now.Add(tt.wantLease)) || expires.After(time.Now().Add(tt.wantLease))) {
+				t.Errorf("want: %v later, got: %v", tt.wantLease, expires)
+			}
+		})
+	}
+}
+
+func TestWebSubReceive(t *testing.T) {
+	now := time.Now()
+	body := []byte("<feed/>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	feed := model.Feed{ID: 1, URL: "https://example.com/1", WebSubSecret: "secret"}
+	parser := mockParser{items: []*gofeed.Item{{Link: "https://example.com/entry1", Title: "title1", PublishedParsed: &now}}}
+
+	tests := []struct {
+		name        string
+		feedID      uint
+		signature   string
+		subs        []model.Subscription
+		wantEntries int
+		wantErr     error
+	}{
+		{name: "valid signature", feedID: 1, signature: signature, subs: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: feed}}, wantEntries: 1},
+		{name: "no enabled subscription", feedID: 1, signature: signature, subs: []model.Subscription{{ID: 1, ChannelID: "123", FeedID: 1, Feed: feed, Status: model.SubscriptionDisabled}}},
+		{name: "invalid signature", feedID: 1, signature: "sha256=00", wantErr: repository.ErrInvalidSignature},
+		{name: "unsupported method", feedID: 1, signature: "md5=" + signature[len("sha256="):], wantErr: repository.ErrInvalidSignature},
+		{name: "missing signature", feedID: 1, wantErr: repository.ErrInvalidSignature},
+		{name: "unknown feed", feedID: 2, signature: signature, wantErr: repository.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			updated := []model.Feed{}
+			fr := mockWebSubFeedRepository{feeds: map[uint]model.Feed{1: feed}, updated: &updated}
+			sr := mockSubscription{mockFindByModel: func() ([]model.Subscription, error) { return tt.subs, nil }}
+			ru := usecase.NewRssEntriesUsecase(mockRssEnrtyRepository{}, fr, mockStatusRepository{status: map[uint]string{}}, nil, usecase.PollLimits{}, usecase.Schedule{Interval: 10 * time.Minute}, usecase.Retention{})
+			w := usecase.NewWebSubUsecase(fr, sr, mockHub{}, parser, ru, usecase.WebSub{CallbackURL: "https://bot.example.com/websub"})
+
+			// test
+			entries, _, err := w.Receive(context.Background(), tt.feedID, body, tt.signature)
+
+			// assert
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want: %v, got: %v", tt.wantErr, err)
+			}
+			if len(entries) != tt.wantEntries {
+				t.Errorf("want: %d entries, got: %v", tt.wantEntries, entries)
+			}
+		})
+	}
+}
+
+func TestWebSubReceivePollState(t *testing.T) {
+	now := databasetest.Now()
+	body := []byte("<feed/>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	sr := persistence.NewSubscriptionPersistence(db)
+	ru := usecase.NewRssEntriesUsecase(rr, fr, sr, nil, usecase.PollLimits{}, usecase.Schedule{FailureThreshold: 3}, usecase.Retention{})
+	parser := mockParser{items: []*gofeed.Item{{GUID: "1", Title: "title1", PublishedParsed: &now}}}
+	w := usecase.NewWebSubUsecase(fr, sr, mockHub{}, parser, ru, usecase.WebSub{CallbackURL: "https://bot.example.com/websub"})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/index.xml"})
+	fr.UpdateWebSub(model.Feed{ID: feed.ID, WebSubSecret: "secret"})
+	// the state the polls left, the feed failing
+	feed.ETag = `"1"`
+	feed.ConsecutiveFailures = 3
+	feed.LastError = "503 Service Unavailable"
+	feed.NextDueAt = now.Add(time.Hour)
+	feed.Repairs = []string{"invalid characters"}
+	fr.Save([]model.Feed{feed})
+	db.Create(&model.Subscription{ChannelID: "123", FeedID: feed.ID, Status: model.SubscriptionBroken, CreatedAt: now.Add(-time.Hour)})
+
+	// test
+	entries, notices, err := w.Receive(context.Background(), feed.ID, body, signature)
+	got, _ := fr.Find(feed.ID)
+
+	// assert
+	if err != nil {
+		t.Fatalf("want: nil, got: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("want: the pushed entry, got: %v", entries)
+	}
+	if len(notices) != 0 {
+		t.Errorf("want: no notice, got: %v", notices)
+	}
+	if got.ETag != `"1"` || got.ConsecutiveFailures != 3 || got.LastError == "" || !got.NextDueAt.Equal(now.Add(time.Hour)) || !got.LastCheckedAt.IsZero() {
+		t.Errorf("want: the poll state kept, got: %+v", got)
+	}
+	if len(got.Repairs) != 0 {
+		t.Errorf("want: the repairs of the pushed document, got: %v", got.Repairs)
+	}
+}
+
+func TestWebSubReceivePrune(t *testing.T) {
+	published := databasetest.Now()
+	items := []*gofeed.Item{
+		{GUID: "1", Title: "title1", PublishedParsed: &published},
+		{GUID: "2", Title: "title2", PublishedParsed: &published},
+	}
+	m := mockRss{mockFetch: func() ([]*gofeed.Item, error) { return items, nil }}
+	body := []byte("<feed/>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	// setup
+	db := databasetest.NewDB(t)
+	defer database.CloseDB(db)
+	rr := persistence.NewRssEntryPersistence(db)
+	fr := persistence.NewFeedPersistence(db)
+	sr := persistence.NewSubscriptionPersistence(db)
+	ru := usecase.NewRssEntriesUsecase(rr, fr, sr, m, usecase.PollLimits{}, usecase.Schedule{}, usecase.Retention{MaxAge: time.Nanosecond, MaxEntries: 1, MaxAttempts: 10})
+	// the push only holds the new entry and the updated one
+	parser := mockParser{items: []*gofeed.Item{{GUID: "2", Title: "title2 updated", PublishedParsed: &published}, {GUID: "3", Title: "title3", PublishedParsed: &published}}}
+	w := usecase.NewWebSubUsecase(fr, sr, mockHub{}, parser, ru, usecase.WebSub{CallbackURL: "https://bot.example.com/websub"})
+
+	// prepare
+	feed, _ := fr.FindOrCreate(model.Feed{URL: "https://example.com/index.xml"})
+	fr.UpdateWebSub(model.Feed{ID: feed.ID, WebSubSecret: "secret"})
+	sub := model.Subscription{ChannelID: "123", FeedID: feed.ID, CreatedAt: published.Add(-time.Hour)}
+	db.Create(&sub)
+	sub.Feed = feed
+	subs := []model.Subscription{sub}
+	ru.CheckNewEntries(context.Background(), subs)
+	sent := func() { db.Model(&model.Delivery{}).Where("1 = 1").Update("status", model.DeliverySent) }
+	sent()
+
+	// test
+	pushed, _, err := w.Receive(context.Background(), feed.ID, body, signature)
+	sent()
+	// the entry the push lacks is still in the feed
+	ru.Prune()
+	kept := []model.RssEntry{}
+	db.Order("id").Find(&kept)
+	// the first entry leaves the feed
+	items = []*gofeed.Item{parser.items[0], parser.items[1]}
+	again, _ := ru.CheckNewEntries(context.Background(), subs)
+	ru.Prune()
+	left := []model.RssEntry{}
+	db.Order("id").Find(&left)
+
+	// assert
+	if err != nil {
+		t.Fatalf("want: nil, got: %v", err)
+	}
+	if len(pushed) != 2 {
+		t.Errorf("want: the new and the updated entries, got: %v", pushed)
+	}
+	identities := func(entries []model.RssEntry) []string {
+		res := []string{}
+		for _, e := range entries {
+			res = append(res, e.Identity)
+		}
+		return res
+	}
+	if diff := cmp.Diff(identities(kept), []string{"guid:1", "guid:2", "guid:3"}); diff != "" {
+		t.Errorf("kept Diff: %v", diff)
+	}
+	if len(again) != 0 {
+		t.Errorf("want: nothing posted again, got: %v", again)
+	}
+	if diff := cmp.Diff(identities(left), []string{"guid:2", "guid:3"}); diff != "" {
+		t.Errorf("left Diff: %v", diff)
+	}
+}